@@ -18,10 +18,12 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 */
 
 import (
+	"context"
 	"fmt"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/gcache"
 	"github.com/fragforce/fragevents/lib/kdb"
+	"github.com/fragforce/fragevents/lib/tracing"
 	"github.com/mitchellh/go-homedir"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -35,6 +37,7 @@ var cfgFile string
 var rootLog *logrus.Logger
 var log *logrus.Entry
 var AmDebugging bool
+var tracingShutdown tracing.ShutdownFunc
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -46,6 +49,14 @@ var rootCmd = &cobra.Command{
 			"args": args,
 		})
 
+		log.Info("Setting up tracing")
+		shutdown, err := tracing.Init(context.Background(), cmd.Name())
+		if err != nil {
+			log.WithError(err).Fatal("Problem setting up tracing")
+			return
+		}
+		tracingShutdown = shutdown
+
 		log.Info("Setting up Redis clients")
 		if err := df.GlobalInit(log); err != nil {
 			log.WithError(err).Fatal("Problem setting up redis clients")
@@ -92,6 +103,13 @@ var rootCmd = &cobra.Command{
 			log.WithError(err).Error("Problem shutting down groupcache server")
 		}
 
+		// Flush any spans still buffered
+		ctx, canc := context.WithTimeout(context.Background(), viper.GetDuration("tracing.shutdown.timeout"))
+		defer canc()
+		if err := tracingShutdown(ctx); err != nil {
+			log.WithError(err).Error("Problem shutting down tracing")
+		}
+
 		log.Debug("All done")
 	},
 }
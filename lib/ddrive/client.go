@@ -0,0 +1,230 @@
+package ddrive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/metrics"
+	"github.com/ptdave20/donordrive"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiEvents                = "api/events"
+	apiEventTeams            = "api/events/%d/teams"
+	apiTeam                  = "api/teams/%d"
+	apiTeamParticipants      = "api/teams/%d/participants"
+	apiParticipantDetails    = "api/participants/%d"
+	apiParticipantDonations  = "api/participants/%d/donations"
+	defaultRetryAfterSeconds = 60
+)
+
+// Client talks to a single DonorDrive instance - uses the donordrive lib's types
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+	Limiter *Limiter // Optional - shared rate limit for all api calls
+}
+
+// StatusError is returned when DonorDrive gives us a non-200
+type StatusError struct {
+	Code       int
+	URL        string
+	RetryAfter time.Duration // Only set for 429s and 503s
+}
+
+var (
+	ErrNotFound = errors.New("not found in donordrive")
+	defLock     = &sync.Mutex{}
+)
+
+func init() {
+	viper.SetDefault("donordrive.timeout", time.Second*15)
+	viper.SetDefault("donordrive.page.size", 100)  // DonorDrive's max limit for list calls
+	viper.SetDefault("donordrive.page.limit", 500) // Most pages to fetch for one list - in case offset gets ignored
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("donordrive returned %d for %s", e.Code, e.URL)
+}
+
+//Is lets errors.Is(err, ErrNotFound) work for 404s
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNotFound && e.Code == http.StatusNotFound
+}
+
+//IsNotFound checks if the error means the requested thing doesn't exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+//NewClient creates a client for the given DonorDrive base url
+func NewClient(baseURL string) *Client {
+	baseURL = strings.TrimSpace(baseURL)
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Client{
+		BaseURL: baseURL,
+		HTTP: &http.Client{
+			Timeout:   viper.GetDuration("donordrive.timeout"),
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+//getJSON fetches the api path and decodes the json body into out - endpoint is the unformatted path, for metrics
+func (c *Client) getJSON(ctx context.Context, endpoint string, path string, out interface{}) error {
+	u := c.BaseURL + path
+	if c.Limiter != nil {
+		if err := c.Limiter.Wait(ctx); errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			return err
+		} else if err != nil {
+			// Don't let a limiter problem stop us from fetching
+			df.Log.WithError(err).Warn("Problem with donordrive rate limiter - skipping it")
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		metrics.ObserveDonorDrive(endpoint, 0, time.Since(start))
+		return err
+	}
+	defer res.Body.Close()
+	metrics.ObserveDonorDrive(endpoint, res.StatusCode, time.Since(start))
+
+	if res.StatusCode != http.StatusOK {
+		sErr := &StatusError{
+			Code: res.StatusCode,
+			URL:  u,
+		}
+		if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+			sErr.RetryAfter = parseRetryAfter(res.Header.Get("Retry-After"))
+			if c.Limiter != nil {
+				if err := c.Limiter.Backoff(ctx, sErr.RetryAfter); err != nil {
+					df.Log.WithError(err).Warn("Problem setting donordrive backoff")
+				}
+			}
+		}
+		return sErr
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+//parseRetryAfter handles both the seconds and http-date forms of Retry-After
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return time.Second * defaultRetryAfterSeconds
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Second * time.Duration(secs)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return time.Second * defaultRetryAfterSeconds
+}
+
+//Ping checks if the DonorDrive instance is reachable - anything but a 5xx counts as up
+func (c *Client) Ping(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return res.StatusCode, &StatusError{Code: res.StatusCode, URL: c.BaseURL}
+	}
+	return res.StatusCode, nil
+}
+
+func (c *Client) GetEvents(ctx context.Context) ([]donordrive.Event, error) {
+	var ret []donordrive.Event
+	if err := c.getJSON(ctx, apiEvents, apiEvents, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//GetEventTeams fetches all of the event's teams, a page at a time until a short page comes back
+func (c *Client) GetEventTeams(ctx context.Context, eventID int) ([]donordrive.Team, error) {
+	size := viper.GetInt("donordrive.page.size")
+	if size <= 0 {
+		size = 100
+	}
+	ret := make([]donordrive.Team, 0)
+	for i := 0; i < viper.GetInt("donordrive.page.limit"); i++ {
+		var page []donordrive.Team
+		path := pagedPath(fmt.Sprintf(apiEventTeams, eventID), size, i*size)
+		if err := c.getJSON(ctx, apiEventTeams, path, &page); err != nil {
+			return nil, err
+		}
+		ret = append(ret, page...)
+		if len(page) < size {
+			return ret, nil
+		}
+	}
+	df.Log.WithField("event.id", eventID).Warn("Hit the page limit getting event teams - list may be cut off")
+	return ret, nil
+}
+
+//pagedPath adds DonorDrive's limit/offset params to the api path
+func pagedPath(path string, limit int, offset int) string {
+	return fmt.Sprintf("%s?limit=%d&offset=%d", path, limit, offset)
+}
+
+func (c *Client) GetTeam(ctx context.Context, teamID int) (*donordrive.Team, error) {
+	ret := donordrive.Team{}
+	if err := c.getJSON(ctx, apiTeam, fmt.Sprintf(apiTeam, teamID), &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetTeamParticipants(ctx context.Context, teamID int) ([]donordrive.Participant, error) {
+	var ret []donordrive.Participant
+	if err := c.getJSON(ctx, apiTeamParticipants, fmt.Sprintf(apiTeamParticipants, teamID), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c *Client) GetParticipantDetails(ctx context.Context, participantID int) (*donordrive.Participant, error) {
+	ret := donordrive.Participant{}
+	if err := c.getJSON(ctx, apiParticipantDetails, fmt.Sprintf(apiParticipantDetails, participantID), &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func (c *Client) GetParticipantDonations(ctx context.Context, participantID int) ([]donordrive.Donation, error) {
+	var ret []donordrive.Donation
+	if err := c.getJSON(ctx, apiParticipantDonations, fmt.Sprintf(apiParticipantDonations, participantID), &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
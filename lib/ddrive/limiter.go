@@ -0,0 +1,212 @@
+package ddrive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"math"
+	"time"
+)
+
+// Limiter is a cluster wide token bucket, stored in redis, plus a global backoff for when DonorDrive throttles us
+type Limiter struct {
+	Name  string
+	Rate  float64 // Tokens added per second
+	Burst int     // Max tokens in the bucket
+}
+
+// LimiterStatus is the current state of the shared budget
+type LimiterStatus struct {
+	Name             string   `json:"name"`
+	Rate             float64  `json:"rate-per-second"`
+	Burst            int      `json:"burst"`
+	TokensAvailable  float64  `json:"tokens-available"`
+	NextRequest      *float64 `json:"next-request-seconds,omitempty"` // Until there's budget for a request - nil if there never will be (zero rate)
+	BackoffRemaining float64  `json:"backoff-remaining-seconds"`
+}
+
+var (
+	// takeScript refills then takes ARGV[3] tokens - returns {allowed, ms to wait, tokens*1000}
+	// A zero rate never refills - the wait is -1 as there's no point waiting
+	takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if rate > 0 then
+	tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+end
+local allowed = 0
+local wait = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+elseif rate > 0 then
+	wait = math.ceil((requested - tokens) * 1000 / rate)
+else
+	wait = -1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+if rate > 0 then
+	redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+else
+	redis.call("PEXPIRE", KEYS[1], 3600000)
+end
+return {allowed, wait, math.floor(tokens * 1000)}
+`)
+	// backoffScript only ever extends the backoff - returns the backoff in ms
+	backoffScript = redis.NewScript(`
+local cur = redis.call("PTTL", KEYS[1])
+local want = tonumber(ARGV[1])
+if cur < want then
+	redis.call("SET", KEYS[1], "1", "PX", want)
+	return want
+end
+return cur
+`)
+	ErrBadLimiterReply = errors.New("unexpected reply from rate limiter script")
+)
+
+func init() {
+	viper.SetDefault("donordrive.ratelimit.enabled", true)
+	viper.SetDefault("donordrive.ratelimit.rate", 5.0) // Requests per second across all dynos
+	viper.SetDefault("donordrive.ratelimit.burst", 20)
+	viper.SetDefault("donordrive.ratelimit.maxwait", time.Second*30) // Longest we'll wait on the limiter for one request
+}
+
+//NewLimiter creates a limiter using the rate/burst from viper
+func NewLimiter(name string) *Limiter {
+	return &Limiter{
+		Name:  name,
+		Rate:  viper.GetFloat64("donordrive.ratelimit.rate"),
+		Burst: viper.GetInt("donordrive.ratelimit.burst"),
+	}
+}
+
+func (l *Limiter) bucketKey() string {
+	return fmt.Sprintf("ratelimit-%s-bucket", l.Name)
+}
+
+func (l *Limiter) backoffKey() string {
+	return fmt.Sprintf("ratelimit-%s-backoff", l.Name)
+}
+
+func (l *Limiter) client() (*redis.Client, error) {
+	return df.QuickClient(df.RPoolRateLimit, true)
+}
+
+//take runs the bucket script - requested can be 0 to just peek. The wait is negative if it'll never be allowed.
+func (l *Limiter) take(ctx context.Context, rClient *redis.Client, requested int) (bool, time.Duration, float64, error) {
+	raw, err := takeScript.Run(ctx, rClient, []string{l.bucketKey()}, l.Rate, l.Burst, requested).Result()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	res, ok := raw.([]interface{})
+	if !ok || len(res) != 3 {
+		return false, 0, 0, ErrBadLimiterReply
+	}
+	allowed, ok1 := res[0].(int64)
+	wait, ok2 := res[1].(int64)
+	tokens, ok3 := res[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return false, 0, 0, ErrBadLimiterReply
+	}
+	return allowed == 1, time.Millisecond * time.Duration(wait), float64(tokens) / 1000.0, nil
+}
+
+//Wait blocks until there's budget for one request and we're not backing off
+func (l *Limiter) Wait(ctx context.Context) error {
+	rClient, err := l.client()
+	if err != nil {
+		return err
+	}
+
+	ctx, canc := context.WithTimeout(ctx, viper.GetDuration("donordrive.ratelimit.maxwait"))
+	defer canc()
+	for {
+		backoff, err := rClient.PTTL(ctx, l.backoffKey()).Result()
+		if err != nil {
+			return err
+		}
+		wait := backoff
+		if backoff <= 0 {
+			allowed, tWait, _, err := l.take(ctx, rClient, 1)
+			if err != nil {
+				return err
+			}
+			if allowed {
+				return nil
+			}
+			if tWait < 0 {
+				// Zero rate - nothing to wait for, so it's just like running out of time
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			wait = tWait
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+//Backoff makes every dyno hold off on requests for at least d
+func (l *Limiter) Backoff(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	rClient, err := l.client()
+	if err != nil {
+		return err
+	}
+	return backoffScript.Run(ctx, rClient, []string{l.backoffKey()}, d.Milliseconds()).Err()
+}
+
+//Status reports the current budget without using any of it
+func (l *Limiter) Status(ctx context.Context) (*LimiterStatus, error) {
+	rClient, err := l.client()
+	if err != nil {
+		return nil, err
+	}
+	_, _, tokens, err := l.take(ctx, rClient, 0)
+	if err != nil {
+		return nil, err
+	}
+	backoff, err := rClient.PTTL(ctx, l.backoffKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if backoff < 0 {
+		backoff = 0
+	}
+	ret := &LimiterStatus{
+		Name:             l.Name,
+		Rate:             l.Rate,
+		Burst:            l.Burst,
+		TokensAvailable:  tokens,
+		BackoffRemaining: backoff.Seconds(),
+	}
+	switch {
+	case tokens >= 1:
+		next := backoff.Seconds()
+		ret.NextRequest = &next
+	case l.Rate > 0:
+		next := math.Max(backoff.Seconds(), (1-tokens)/l.Rate)
+		ret.NextRequest = &next
+	}
+	return ret, nil
+}
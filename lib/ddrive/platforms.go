@@ -0,0 +1,80 @@
+package ddrive
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/ptdave20/donordrive"
+	"github.com/spf13/viper"
+	"regexp"
+)
+
+var (
+	ErrUnknownPlatform = errors.New("unknown donordrive platform")
+	ErrNoPlatformURL   = errors.New("no url set for donordrive platform")
+	platformRe         = regexp.MustCompile(`^[a-z0-9-]+$`)
+	platformClients    = make(map[string]*Client)
+)
+
+func init() {
+	viper.SetDefault("donordrive.platforms", []string{df.PlatformExtraLife}) // Platform ids we'll talk to - each needs donordrive.platform.<id>.url
+	viper.SetDefault("donordrive.platform.default", df.PlatformExtraLife)    // Used for monitors/routes that don't give a platform
+	viper.SetDefault(platformURLKey(df.PlatformExtraLife), donordrive.ExtraLifeUrl)
+}
+
+func platformURLKey(platform string) string {
+	return fmt.Sprintf("donordrive.platform.%s.url", platform)
+}
+
+//DefaultPlatform is the platform id used when none is given
+func DefaultPlatform() string {
+	return viper.GetString("donordrive.platform.default")
+}
+
+//Platforms lists the configured platform ids
+func Platforms() []string {
+	return viper.GetStringSlice("donordrive.platforms")
+}
+
+//ResolvePlatform maps an empty platform to the default one and makes sure the result is configured
+func ResolvePlatform(platform string) (string, error) {
+	if platform == "" {
+		platform = DefaultPlatform()
+	}
+	// 'default' is a config key, not a platform
+	if !platformRe.MatchString(platform) || platform == "default" {
+		return "", fmt.Errorf("%w: %q", ErrUnknownPlatform, platform)
+	}
+	for _, p := range Platforms() {
+		if p == platform {
+			return platform, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownPlatform, platform)
+}
+
+//ForPlatform returns the shared client for the given platform - empty means the default platform
+func ForPlatform(platform string) (*Client, error) {
+	platform, err := ResolvePlatform(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	defLock.Lock()
+	defer defLock.Unlock()
+	if client, ok := platformClients[platform]; ok {
+		return client, nil
+	}
+
+	u := viper.GetString(platformURLKey(platform))
+	if u == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoPlatformURL, platform)
+	}
+	client := NewClient(u)
+	if viper.GetBool("donordrive.ratelimit.enabled") {
+		// Each instance has its own limits
+		client.Limiter = NewLimiter("donordrive-" + platform)
+	}
+	platformClients[platform] = client
+	return client, nil
+}
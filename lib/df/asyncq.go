@@ -1,8 +1,23 @@
 package df
 
-import "github.com/hibiken/asynq"
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"sync"
+)
 
-var aClient *asynq.Client
+var (
+	aClient        *asynq.Client
+	aRedis         *redis.Client
+	aRedisLock     = &sync.Mutex{}
+	ErrAsyncQRedis = errors.New("unexpected asynq redis client type")
+)
+
+func init() {
+	RegisterReadyCheck("asynq-redis", true, asyncQReadyCheck)
+}
 
 //CreateAsyncQClient creates a brand-new client - used GetAsyncQClient normally
 func CreateAsyncQClient() *asynq.Client {
@@ -20,3 +35,23 @@ func GetAsyncQClient() *asynq.Client {
 	}
 	return aClient
 }
+
+//asyncQReadyCheck makes sure the redis used by asynq is reachable
+func asyncQReadyCheck(ctx context.Context) (string, error) {
+	aRedisLock.Lock()
+	if aRedis == nil {
+		c, ok := BuildAsyncQRedis().MakeRedisClient().(*redis.Client)
+		if !ok {
+			aRedisLock.Unlock()
+			return "", ErrAsyncQRedis
+		}
+		aRedis = c
+	}
+	client := aRedis
+	aRedisLock.Unlock()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
@@ -0,0 +1,91 @@
+package df
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"sync"
+)
+
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+var (
+	magicGzip          = []byte{0x1f, 0x8b}
+	magicZstd          = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zstdEnc            *zstd.Encoder
+	zstdDec            *zstd.Decoder
+	zstdLock           = &sync.Mutex{}
+	ErrUnknownCompress = errors.New("unknown compression")
+)
+
+//getZstd lazily creates the shared zstd encoder/decoder - both are safe for concurrent EncodeAll/DecodeAll
+func getZstd() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdLock.Lock()
+	defer zstdLock.Unlock()
+	if zstdEnc == nil {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, nil, err
+		}
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		zstdEnc, zstdDec = enc, dec
+	}
+	return zstdEnc, zstdDec, nil
+}
+
+//Compress compresses data with the given algo - CompressNone, or an empty algo, returns data as is
+func Compress(algo string, data []byte) ([]byte, error) {
+	switch algo {
+	case CompressNone, "":
+		return data, nil
+	case CompressGzip:
+		bf := new(bytes.Buffer)
+		zw := gzip.NewWriter(bf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return bf.Bytes(), nil
+	case CompressZstd:
+		enc, _, err := getZstd()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, make([]byte, 0, len(data)/4)), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompress, algo)
+	}
+}
+
+//Decompress undoes Compress based on the data's magic bytes - anything else (e.g. plain json) is returned as is
+func Decompress(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, magicGzip):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case bytes.HasPrefix(data, magicZstd):
+		_, dec, err := getZstd()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(data, nil)
+	default:
+		return data, nil
+	}
+}
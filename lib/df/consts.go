@@ -6,15 +6,21 @@ const (
 	// 	Monitor types - Names
 	MonitorNameTeam        = "Team"
 	MonitorNameParticipant = "Participant"
+	MonitorNameEvent       = "Event"
 	// 	Request Types - URL Pattern
 	RTypeTeam        = "team"
 	RTypeParticipant = "participant"
+	RTypeEvent       = "event"
+	//	DonorDrive Platforms - ids used in config, routes, and cache keys
+	PlatformExtraLife = "extralife"
 	//	Redis Pool Names & Redis Database Numbers
 	//	The defaults are set in `df/redis.go`
 	RPoolGroupCache   = "groupcache"
 	RPoolGroupCacheDB = 2
 	RPoolMonitoring   = "monitoring"
 	RPoolMonitoringDB = 3
+	RPoolRateLimit    = "ratelimit"
+	RPoolRateLimitDB  = 4
 	//	Kafka Header Keys
 	KHeaderKeyTeamID        = "team-id"
 	KHeaderKeyTeamName      = "team-name"
@@ -24,13 +30,25 @@ const (
 	KHeaderKeyDisplayName   = "display-name"
 	KHeaderKeyCampaignName  = "campaign-name"
 	KHeaderKeyParticipantID = "participant-id"
+	KHeaderKeyPlatform      = "platform"
+	KHeaderKeyTeamCount     = "team-count"
+	KHeaderKeyOwner         = "owner"
+	KHeaderKeyLabel         = "label" // Repeated - one per monitor label
+	KHeaderKeyChangeType    = "change-type"
+	KHeaderKeyMilestoneID   = "milestone-id"
 
 	//	Text parser templates - Used as names for text/templates
 	TextTemplateTeamMonitor        = "team-monitor-template"
 	TextTemplateParticipantMonitor = "participant-monitor-template"
+	TextTemplateEventMonitor       = "event-monitor-template"
 	// 	Topic Types - Kafka topic names/types - needs prefix usually
 	KTopicEvents       = "events"
 	KTopicTeams        = "teams"
 	KTopicParticipants = "participants"
 	KTopicDonations    = "donations"
+	KTopicEventInfo    = "event-info" // DonorDrive events, not our change events
+	//	Change Types - for change events we publish to the events topic
+	ChangeTypeParticipantJoined = "participant-joined"
+	ChangeTypeParticipantLeft   = "participant-left"
+	ChangeTypeMilestoneReached  = "milestone-reached"
 )
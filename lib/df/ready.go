@@ -0,0 +1,89 @@
+package df
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReadyCheckFunc checks a single dependency - detail is optional, human-readable info
+type ReadyCheckFunc func(ctx context.Context) (detail string, err error)
+
+type readyCheck struct {
+	name     string
+	critical bool
+	check    ReadyCheckFunc
+}
+
+// ReadyStatus is the result of a single readiness check
+type ReadyStatus struct {
+	Ok        bool    `json:"ok"`
+	Critical  bool    `json:"critical"` // If false, failing doesn't make us un-ready
+	LatencyMS float64 `json:"latency-ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+var (
+	readyChecks     map[string]*readyCheck
+	readyChecksLock = &sync.Mutex{}
+)
+
+//RegisterReadyCheck adds (or replaces) a named readiness check
+func RegisterReadyCheck(name string, critical bool, f ReadyCheckFunc) {
+	readyChecksLock.Lock()
+	defer readyChecksLock.Unlock()
+
+	if readyChecks == nil {
+		readyChecks = make(map[string]*readyCheck)
+	}
+	readyChecks[name] = &readyCheck{
+		name:     name,
+		critical: critical,
+		check:    f,
+	}
+}
+
+//RunReadyChecks runs all registered checks in parallel and reports if all critical checks passed
+func RunReadyChecks(ctx context.Context) (bool, map[string]*ReadyStatus) {
+	readyChecksLock.Lock()
+	checks := make([]*readyCheck, 0, len(readyChecks))
+	for _, c := range readyChecks {
+		checks = append(checks, c)
+	}
+	readyChecksLock.Unlock()
+
+	ret := make(map[string]*ReadyStatus)
+	rLock := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c *readyCheck) {
+			defer wg.Done()
+			start := time.Now()
+			detail, err := c.check(ctx)
+			status := ReadyStatus{
+				Ok:        err == nil,
+				Critical:  c.critical,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000.0,
+				Detail:    detail,
+			}
+			if err != nil {
+				status.Error = err.Error()
+			}
+
+			rLock.Lock()
+			ret[c.name] = &status
+			rLock.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, status := range ret {
+		if status.Critical && !status.Ok {
+			ready = false
+		}
+	}
+	return ready, ret
+}
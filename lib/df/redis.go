@@ -36,6 +36,7 @@ func init() {
 	viper.SetDefault("redis.retries", 6)
 	viper.SetDefault(MakeCfgKey(RPoolGroupCache, "db"), RPoolGroupCacheDB)
 	viper.SetDefault(MakeCfgKey(RPoolMonitoring, "db"), RPoolMonitoringDB)
+	viper.SetDefault(MakeCfgKey(RPoolRateLimit, "db"), RPoolRateLimitDB)
 }
 
 func GlobalInit(log *logrus.Entry) error {
@@ -81,6 +82,7 @@ func (p *RedisPools) GetCreatePool(name string) *RedisPool {
 
 	if _, ok := p.pools[name]; !ok {
 		p.pools[name] = p.newRedisPool(name)
+		RegisterReadyCheck("redis-"+name, true, p.pools[name].ReadyCheck)
 	}
 
 	return p.pools[name]
@@ -94,6 +96,18 @@ func (p *RedisPool) TestClient(client *redis.Client) bool {
 	return true
 }
 
+//ReadyCheck is used by the readiness endpoint to make sure the pool's redis is reachable
+func (p *RedisPool) ReadyCheck(ctx context.Context) (string, error) {
+	client, err := p.GetClient(false)
+	if err != nil {
+		return "", err
+	}
+	if err := client.Ping(ctx).Err(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("db %d", client.Options().DB), nil
+}
+
 func (p *RedisPool) GetClient(retry bool) (*redis.Client, error) {
 	p.clientLock.Lock()
 	defer p.clientLock.Unlock()
@@ -8,9 +8,11 @@ import (
 
 type CachedTeam struct {
 	donordrive.Team `json:"team"`
-	FetchedAt       time.Time `json:"fetched-at"` // Use team.GetFetchedAt()
-	RawData         []byte    `json:"-"`          // Raw copy of json data - if we already have it
-	RawTeamData     []byte    `json:"-"`          // Raw copy of json data - if we already have it - For team only, not cached
+	Platform        string    `json:"platform,omitempty"` // DonorDrive platform id it came from
+	FetchedAt       time.Time `json:"fetched-at"`         // Use team.GetFetchedAt()
+	Stale           bool      `json:"stale,omitempty"`    // Origin fetch failed - this is the last good value
+	RawData         []byte    `json:"-"`                  // Raw copy of json data - if we already have it
+	RawTeamData     []byte    `json:"-"`                  // Raw copy of json data - if we already have it - For team only, not cached
 }
 
 func (c *CachedTeam) GetFetchedAt() string {
@@ -43,9 +45,11 @@ func (c *CachedTeam) GetRawTeamData() ([]byte, error) {
 
 type CachedParticipants struct {
 	Participants []donordrive.Participant `json:"participants"`
-	Count        int                      `json:"count"`      // Number of participants
-	FetchedAt    time.Time                `json:"fetched-at"` // Use team.GetFetchedAt()
-	RawData      []byte                   `json:"-"`          // Raw copy of json data - if we already have it
+	Count        int                      `json:"count"`              // Number of participants
+	Platform     string                   `json:"platform,omitempty"` // DonorDrive platform id it came from
+	FetchedAt    time.Time                `json:"fetched-at"`         // Use team.GetFetchedAt()
+	Stale        bool                     `json:"stale,omitempty"`    // Origin fetch failed - this is the last good value
+	RawData      []byte                   `json:"-"`                  // Raw copy of json data - if we already have it
 }
 
 func (c *CachedParticipants) GetFetchedAt() string {
@@ -66,9 +70,11 @@ func (c *CachedParticipants) GetRawData() ([]byte, error) {
 
 type CachedParticipant struct {
 	donordrive.Participant `json:"participant"`
-	FetchedAt              time.Time `json:"fetched-at"` // Use team.GetFetchedAt()
-	RawData                []byte    `json:"-"`          // Raw copy of json data - if we already have it
-	RawParticipantData     []byte    `json:"-"`          // Raw copy of json data - if we already have it - For Participant only, not cached
+	Platform               string    `json:"platform,omitempty"` // DonorDrive platform id it came from
+	FetchedAt              time.Time `json:"fetched-at"`         // Use team.GetFetchedAt()
+	Stale                  bool      `json:"stale,omitempty"`    // Origin fetch failed - this is the last good value
+	RawData                []byte    `json:"-"`                  // Raw copy of json data - if we already have it
+	RawParticipantData     []byte    `json:"-"`                  // Raw copy of json data - if we already have it - For Participant only, not cached
 }
 
 func (c *CachedParticipant) GetFetchedAt() string {
@@ -98,3 +104,99 @@ func (c *CachedParticipant) GetRawParticipantData() ([]byte, error) {
 	}
 	return c.RawParticipantData, nil
 }
+
+type CachedDonations struct {
+	Donations []donordrive.Donation `json:"donations"`
+	Count     int                   `json:"count"`              // Number of donations
+	Platform  string                `json:"platform,omitempty"` // DonorDrive platform id it came from
+	FetchedAt time.Time             `json:"fetched-at"`         // Use team.GetFetchedAt()
+	Stale     bool                  `json:"stale,omitempty"`    // Origin fetch failed - this is the last good value
+	RawData   []byte                `json:"-"`                  // Raw copy of json data - if we already have it
+}
+
+func (c *CachedDonations) GetFetchedAt() string {
+	return c.FetchedAt.UTC().Format(time.RFC3339Nano)
+}
+
+//GetRawData fetches the raw data, recreating if not set
+func (c *CachedDonations) GetRawData() ([]byte, error) {
+	if c.RawData == nil {
+		raw, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		c.RawData = raw
+	}
+	return c.RawData, nil
+}
+
+type CachedEvents struct {
+	Events    []donordrive.Event `json:"events"`
+	Count     int                `json:"count"`              // Number of events
+	Platform  string             `json:"platform,omitempty"` // DonorDrive platform id it came from
+	FetchedAt time.Time          `json:"fetched-at"`         // Use team.GetFetchedAt()
+	Stale     bool               `json:"stale,omitempty"`    // Origin fetch failed - this is the last good value
+}
+
+type CachedEvent struct {
+	EventID   int               `json:"event-id"`
+	Event     *donordrive.Event `json:"event,omitempty"` // Not set if the event isn't in the platform's event list
+	Teams     []donordrive.Team `json:"teams"`
+	TeamCount int               `json:"team-count"`         // Number of teams
+	Platform  string            `json:"platform,omitempty"` // DonorDrive platform id it came from
+	FetchedAt time.Time         `json:"fetched-at"`         // Use event.GetFetchedAt()
+	Stale     bool              `json:"stale,omitempty"`    // Origin fetch failed - this is the last good value
+	RawData   []byte            `json:"-"`                  // Raw copy of json data - if we already have it
+}
+
+func (c *CachedEvent) GetFetchedAt() string {
+	return c.FetchedAt.UTC().Format(time.RFC3339Nano)
+}
+
+//GetRawData fetches the raw data, recreating if not set
+func (c *CachedEvent) GetRawData() ([]byte, error) {
+	if c.RawData == nil {
+		raw, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		c.RawData = raw
+	}
+	return c.RawData, nil
+}
+
+//MarkStale flags the raw json of any of the Cached* types as stale
+func MarkStale(raw []byte) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	fields["stale"] = json.RawMessage("true")
+	return json.Marshal(fields)
+}
+
+// RosterChange is a participant joining or leaving a team - published to the events topic
+type RosterChange struct {
+	Type          string    `json:"type"` // ChangeTypeParticipantJoined or ChangeTypeParticipantLeft
+	Platform      string    `json:"platform,omitempty"`
+	TeamID        int       `json:"team-id"`
+	ParticipantID int       `json:"participant-id"`
+	DisplayName   string    `json:"display-name,omitempty"`
+	At            time.Time `json:"at"` // When we noticed - not when it happened
+}
+
+// MilestoneReached is a team or participant passing one of its milestones - published to the events topic
+type MilestoneReached struct {
+	Type        string    `json:"type"`         // ChangeTypeMilestoneReached
+	MilestoneID string    `json:"milestone-id"` // Same every time this milestone is published for the monitor - for de-duping
+	Milestone   string    `json:"milestone"`    // e.g. percent-50 or amount-1000
+	Percent     *float64  `json:"percent,omitempty"`
+	Amount      float64   `json:"amount"` // What had to be raised to reach it
+	Goal        *float64  `json:"goal,omitempty"`
+	Raised      float64   `json:"raised"` // When it was reached
+	RType       string    `json:"rtype"`  // RTypeTeam or RTypeParticipant
+	ID          int       `json:"id"`
+	Platform    string    `json:"platform,omitempty"`
+	At          time.Time `json:"at"`
+	Backfilled  bool      `json:"backfilled,omitempty"` // Already passed when we first saw it - no event was published
+}
@@ -0,0 +1,42 @@
+package gcache
+
+import (
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("cache.compress.algo", df.CompressZstd) // none, gzip or zstd - groups can override via group.<name>.compression
+	viper.SetDefault("cache.compress.min", 4096)             // Only compress values at least this many bytes - groups can override via group.<name>.compress-min
+}
+
+//groupCompression is the compression algo and min size to use for the group
+func groupCompression(groupName string) (string, int) {
+	algo := viper.GetString(fmt.Sprintf("group.%s.compression", groupName))
+	if algo == "" {
+		algo = viper.GetString("cache.compress.algo")
+	}
+	minKey := fmt.Sprintf("group.%s.compress-min", groupName)
+	min := viper.GetInt("cache.compress.min")
+	if viper.IsSet(minKey) {
+		min = viper.GetInt(minKey)
+	}
+	return algo, min
+}
+
+//compressValue compresses values for the group that are big enough - on error the raw value is used since Fetch handles both
+func compressValue(log *logrus.Entry, groupName string, value []byte) []byte {
+	algo, min := groupCompression(groupName)
+	if len(value) < min {
+		return value
+	}
+	ret, err := df.Compress(algo, value)
+	if err != nil {
+		log.WithError(err).WithField("compress.algo", algo).Warn("Problem compressing cache value - storing it raw")
+		return value
+	}
+	return ret
+}
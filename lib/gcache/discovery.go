@@ -0,0 +1,237 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DiscoveryRedis  = "redis"   // Peers hold an expiring lease in a redis sorted set
+	DiscoveryStatic = "static"  // Fixed list of peer uris from config
+	DiscoveryDNS    = "dns"     // A/AAAA lookup of a name - all peers use the same port
+	DiscoveryDNSSRV = "dns-srv" // SRV lookup of a name - gives host and port per peer
+)
+
+// PeerDiscovery finds the groupcache peers - peers are full uris like http://host:port
+type PeerDiscovery interface {
+	// Name of the discovery method
+	Name() string
+	// Register makes us findable by other peers - may be a no-op
+	Register(ctx context.Context, uri string) error
+	// Remove makes the peer not findable anymore - may be a no-op
+	Remove(ctx context.Context, uri string) error
+	// Peers lists all known peers
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// selfNormaliser is for discovery methods that list peers in a different form than we'd advertise ourselves
+type selfNormaliser interface {
+	// SelfURI gives our uri in the same form Peers lists it
+	SelfURI(ctx context.Context, host string, port int) (string, error)
+}
+
+// redisDiscovery keeps peers in a sorted set scored by their last heartbeat - old scores are expired leases
+type redisDiscovery struct {
+	rClient *redis.Client
+	key     string
+	lease   time.Duration
+}
+
+type staticDiscovery struct {
+	peers []string
+}
+
+type dnsDiscovery struct {
+	name   string
+	port   int
+	scheme string
+	srv    bool
+}
+
+var (
+	ErrUnknownDiscovery = errors.New("unknown groupcache discovery method")
+	ErrNoDiscoveryName  = errors.New("no dns name set for groupcache discovery")
+	ErrNoSelfAddrs      = errors.New("no addresses found for our advertise host")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("groupcache.discovery.method", DiscoveryRedis)
+	viper.SetDefault("groupcache.discovery.static.peers", []string{}) // For static - list of peer uris
+	viper.SetDefault("groupcache.discovery.dns.name", "")             // For dns/dns-srv - name to look up
+	viper.SetDefault("groupcache.discovery.dns.port", 0)              // For dns - 0 means use our own listen port
+	viper.SetDefault("groupcache.discovery.dns.scheme", "http")
+}
+
+//NewPeerDiscovery creates the peer discovery set via 'groupcache.discovery.method'
+func NewPeerDiscovery(rClient *redis.Client, listenPort int) (PeerDiscovery, error) {
+	switch method := viper.GetString("groupcache.discovery.method"); method {
+	case DiscoveryRedis:
+		return &redisDiscovery{
+			rClient: rClient,
+			key:     viper.GetString("groupcache.peers.key"),
+			lease:   viper.GetDuration("groupcache.peers.lease"),
+		}, nil
+	case DiscoveryStatic:
+		return &staticDiscovery{
+			peers: viper.GetStringSlice("groupcache.discovery.static.peers"),
+		}, nil
+	case DiscoveryDNS, DiscoveryDNSSRV:
+		name := viper.GetString("groupcache.discovery.dns.name")
+		if name == "" {
+			return nil, ErrNoDiscoveryName
+		}
+		port := viper.GetInt("groupcache.discovery.dns.port")
+		if port == 0 {
+			port = listenPort
+		}
+		return &dnsDiscovery{
+			name:   name,
+			port:   port,
+			scheme: viper.GetString("groupcache.discovery.dns.scheme"),
+			srv:    method == DiscoveryDNSSRV,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDiscovery, method)
+	}
+}
+
+func (d *redisDiscovery) Name() string {
+	return DiscoveryRedis
+}
+
+//now uses redis' clock so dynos with skewed clocks agree on lease expiry
+func (d *redisDiscovery) now(ctx context.Context) (time.Time, error) {
+	return d.rClient.Time(ctx).Result()
+}
+
+//Register takes out or renews our lease - also prunes expired leases
+func (d *redisDiscovery) Register(ctx context.Context, uri string) error {
+	now, err := d.now(ctx)
+	if err != nil {
+		return err
+	}
+	pipe := d.rClient.TxPipeline()
+	pipe.ZAdd(ctx, d.key, &redis.Z{Score: float64(now.UnixMilli()), Member: uri})
+	pipe.ZRemRangeByScore(ctx, d.key, "-inf", fmt.Sprintf("(%d", now.Add(-d.lease).UnixMilli()))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (d *redisDiscovery) Remove(ctx context.Context, uri string) error {
+	return d.rClient.ZRem(ctx, d.key, uri).Err()
+}
+
+//Peers lists the peers with a current lease
+func (d *redisDiscovery) Peers(ctx context.Context) ([]string, error) {
+	now, err := d.now(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.rClient.ZRangeByScore(ctx, d.key, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", now.Add(-d.lease).UnixMilli()),
+		Max: "+inf",
+	}).Result()
+}
+
+func (d *staticDiscovery) Name() string {
+	return DiscoveryStatic
+}
+
+func (d *staticDiscovery) Register(ctx context.Context, uri string) error {
+	return nil
+}
+
+func (d *staticDiscovery) Remove(ctx context.Context, uri string) error {
+	return nil
+}
+
+func (d *staticDiscovery) Peers(ctx context.Context) ([]string, error) {
+	ret := make([]string, len(d.peers))
+	copy(ret, d.peers)
+	return ret, nil
+}
+
+func (d *dnsDiscovery) Name() string {
+	if d.srv {
+		return DiscoveryDNSSRV
+	}
+	return DiscoveryDNS
+}
+
+func (d *dnsDiscovery) Register(ctx context.Context, uri string) error {
+	return nil
+}
+
+func (d *dnsDiscovery) Remove(ctx context.Context, uri string) error {
+	return nil
+}
+
+//SelfURI turns our advertised host into the form lookups give - our ip and the shared port for A/AAAA, or just the
+// scheme and a trailing dot free host for SRV
+func (d *dnsDiscovery) SelfURI(ctx context.Context, host string, port int) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if d.srv {
+		return fmt.Sprintf("%s://%s", d.scheme, net.JoinHostPort(host, fmt.Sprintf("%d", port))), nil
+	}
+
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		var err error
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return "", err
+		}
+		if len(addrs) == 0 {
+			return "", fmt.Errorf("%w: %s", ErrNoSelfAddrs, host)
+		}
+		sort.Strings(addrs)
+	}
+
+	ret := make([]string, len(addrs))
+	for i, addr := range addrs {
+		ret[i] = fmt.Sprintf("%s://%s", d.scheme, net.JoinHostPort(addr, fmt.Sprintf("%d", d.port)))
+	}
+	// Prefer whichever of our addresses the discovery name lists
+	if peers, err := d.Peers(ctx); err == nil {
+		for _, uri := range ret {
+			for _, peer := range peers {
+				if peer == uri {
+					return uri, nil
+				}
+			}
+		}
+	}
+	return ret[0], nil
+}
+
+func (d *dnsDiscovery) Peers(ctx context.Context) ([]string, error) {
+	ret := make([]string, 0)
+	if d.srv {
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			ret = append(ret, fmt.Sprintf("%s://%s", d.scheme, net.JoinHostPort(host, fmt.Sprintf("%d", srv.Port))))
+		}
+	} else {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ret = append(ret, fmt.Sprintf("%s://%s", d.scheme, net.JoinHostPort(addr, fmt.Sprintf("%d", d.port))))
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
@@ -3,10 +3,13 @@ package gcache
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/ddrive"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/ptdave20/donordrive"
 	"github.com/sirupsen/logrus"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,106 +17,415 @@ const (
 	GroupELTeam               = "EL-Team"
 	GroupELParticipants       = "EL-Participants"
 	GroupELParticipantForTeam = "EL-Participants-For-Team"
+	GroupELDonations          = "EL-Participant-Donations"
+	GroupELEvents             = "EL-Events"
+	GroupELEvent              = "EL-Event" // A single event plus its teams
+	EventsKeyAll              = "all" // Only id used in GroupELEvents - still namespaced by platform
+	platformKeySep            = ":"
 )
 
 func init() {
-	donordrive.SetBaseUrl(donordrive.ExtraLifeUrl)
 	doCheckInits()
-	registerGroupF(GroupELTeam, 256, teamGroup)
-	registerGroupF(GroupELParticipants, 256, participantGroup)
-	registerGroupF(GroupELParticipantForTeam, 256, participantsForTeamGroup)
+	df.RegisterReadyCheck("donordrive", false, donorDriveReadyCheck)
+	registerGroupF(GroupELTeam, 256, time.Minute*30, teamGroup)
+	registerGroupF(GroupELParticipants, 256, time.Minute*30, participantGroup)
+	registerGroupF(GroupELParticipantForTeam, 256, time.Minute*30, participantsForTeamGroup)
+	registerGroupF(GroupELDonations, 128, time.Minute*30, donationsGroup)
+	registerGroupF(GroupELEvents, 16, time.Hour*6, eventsGroup)
+	registerGroupF(GroupELEvent, 128, time.Minute*30, eventGroup)
 }
 
-func teamGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, error) {
-	teamID, err := strconv.ParseInt(key, 10, 32)
+//donorDriveReadyCheck makes sure we can reach every configured donordrive platform - anything but a 5xx counts as up
+func donorDriveReadyCheck(ctx context.Context) (string, error) {
+	details := make([]string, 0)
+	for _, platform := range ddrive.Platforms() {
+		client, err := ddrive.ForPlatform(platform)
+		if err != nil {
+			return strings.Join(details, ", "), err
+		}
+		code, err := client.Ping(ctx)
+		if err != nil {
+			return strings.Join(details, ", "), fmt.Errorf("%s: %w", platform, err)
+		}
+		details = append(details, fmt.Sprintf("%s: %s returned %d", platform, client.BaseURL, code))
+	}
+	return strings.Join(details, ", "), nil
+}
+
+//PlatformKey namespaces a group key by donordrive platform - empty means the default platform
+func PlatformKey(platform string, id string) string {
+	if platform == "" {
+		platform = ddrive.DefaultPlatform()
+	}
+	return platform + platformKeySep + id
+}
+
+//ParsePlatformKey splits a key from PlatformKey - a bare id is for the default platform
+func ParsePlatformKey(key string) (platform string, id string, err error) {
+	if idx := strings.LastIndex(key, platformKeySep); idx >= 0 {
+		platform, id = key[:idx], key[idx+len(platformKeySep):]
+	} else {
+		id = key
+	}
+	platform, err = ddrive.ResolvePlatform(platform)
 	if err != nil {
-		log.WithError(err).Error("Problem converting team id from str to int")
-		return nil, err
+		return "", "", err
 	}
-	log = log.WithField("team.id", teamID)
+	return platform, id, nil
+}
+
+//parsePlatformIDKey is ParsePlatformKey for numeric ids
+func parsePlatformIDKey(key string) (string, int, error) {
+	platform, idStr, err := ParsePlatformKey(key)
+	if err != nil {
+		return "", 0, err
+	}
+	id, err := strconv.ParseInt(idStr, 10, 32)
+	if err != nil {
+		return "", 0, err
+	}
+	return platform, int(id), nil // Need int not int64
+}
 
-	log.Warn("Going to fetch team from extra-life")
-	team, err := donordrive.GetTeam(int(teamID)) // Need int not int64
+func teamGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, time.Duration, error) {
+	platform, teamID, err := parsePlatformIDKey(key)
+	if err != nil {
+		log.WithError(err).Info("Problem parsing platform and team id from key")
+		return nil, 0, ErrInvalidKey
+	}
+	log = log.WithFields(logrus.Fields{
+		"team.id":             teamID,
+		"donordrive.platform": platform,
+	})
+
+	client, err := ddrive.ForPlatform(platform)
+	if err != nil {
+		log.WithError(err).Error("Problem getting donordrive client")
+		return nil, 0, err
+	}
+
+	log.Warn("Going to fetch team from donordrive")
+	team, err := client.GetTeam(ctx, teamID)
 	if err != nil {
 		log.WithError(err).Error("Problem fetching team")
-		return nil, err
+		return nil, 0, err
 	}
 	log = log.WithField("team.name", team.Name)
-	log.Warn("Got team from extra-life")
+	log.Warn("Got team from donordrive")
 
 	cTeam := df.CachedTeam{
 		Team:      *team,
+		Platform:  platform,
 		FetchedAt: time.Now().UTC(),
 	}
 	res, err := json.Marshal(&cTeam)
 	if err != nil {
 		log.WithError(err).Error("Problem marshaling team into json")
-		return nil, err
+		return nil, 0, err
 	}
-	log.Warn("Done")
-	// FIXME: Dynamic timeout and/or viper based
-	return res, nil
+
+	eventID := 0
+	if team.EventID != nil {
+		eventID = *team.EventID
+	}
+	ttl := sgc.dynamicTTL(ctx, log, GroupELTeam, platform, eventID, team.StreamIsLive != nil && *team.StreamIsLive)
+	log.WithField("cache.ttl", ttl).Warn("Done")
+	return res, ttl, nil
 }
 
-func participantsForTeamGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, error) {
-	teamID, err := strconv.ParseInt(key, 10, 32)
+func participantsForTeamGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, time.Duration, error) {
+	platform, teamID, err := parsePlatformIDKey(key)
 	if err != nil {
-		log.WithError(err).Error("Problem converting team id from str to int")
-		return nil, err
+		log.WithError(err).Info("Problem parsing platform and team id from key")
+		return nil, 0, ErrInvalidKey
+	}
+	log = log.WithFields(logrus.Fields{
+		"team.id":             teamID,
+		"donordrive.platform": platform,
+	})
+
+	client, err := ddrive.ForPlatform(platform)
+	if err != nil {
+		log.WithError(err).Error("Problem getting donordrive client")
+		return nil, 0, err
 	}
-	log = log.WithField("team.id", teamID)
 
-	log.Warn("Going to fetch team participants from extra-life")
-	tps, err := donordrive.GetTeamParticipants(int(teamID)) // Need int not int64
+	log.Warn("Going to fetch team participants from donordrive")
+	tps, err := client.GetTeamParticipants(ctx, teamID)
 	if err != nil {
 		log.WithError(err).Error("Problem fetching team participants")
-		return nil, err
+		return nil, 0, err
 	}
 	log = log.WithField("participants.count", len(tps))
-	log.Warn("Got team participants from extra-life")
+	log.Warn("Got team participants from donordrive")
 
 	cTeam := df.CachedParticipants{
 		Participants: tps,
 		Count:        len(tps),
+		Platform:     platform,
 		FetchedAt:    time.Now().UTC(),
 	}
 	res, err := json.Marshal(&cTeam)
 	if err != nil {
 		log.WithError(err).Error("Problem marshaling participants team into json")
-		return nil, err
+		return nil, 0, err
 	}
-	log.Warn("Done")
-	// FIXME: Dynamic timeout and/or viper based
-	return res, nil
+
+	// Any live stream on the team counts as live
+	eventID := 0
+	live := false
+	for _, p := range tps {
+		eventID = p.EventId
+		live = live || p.StreamIsLive
+	}
+	ttl := sgc.dynamicTTL(ctx, log, GroupELParticipantForTeam, platform, eventID, live)
+	log.WithField("cache.ttl", ttl).Warn("Done")
+	return res, ttl, nil
 }
 
-func participantGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, error) {
-	participantID, err := strconv.ParseInt(key, 10, 32)
+func participantGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, time.Duration, error) {
+	platform, participantID, err := parsePlatformIDKey(key)
 	if err != nil {
-		log.WithError(err).Error("Problem converting participant id from str to int")
-		return nil, err
+		log.WithError(err).Info("Problem parsing platform and participant id from key")
+		return nil, 0, ErrInvalidKey
 	}
-	log = log.WithField("participant.id", participantID)
+	log = log.WithFields(logrus.Fields{
+		"participant.id":      participantID,
+		"donordrive.platform": platform,
+	})
 
-	log.Warn("Going to fetch participant from extra-life")
-	participant, err := donordrive.GetParticipantDetails(int(participantID)) // Need int not int64
+	client, err := ddrive.ForPlatform(platform)
+	if err != nil {
+		log.WithError(err).Error("Problem getting donordrive client")
+		return nil, 0, err
+	}
+
+	log.Warn("Going to fetch participant from donordrive")
+	participant, err := client.GetParticipantDetails(ctx, participantID)
 	if err != nil {
 		log.WithError(err).Error("Problem fetching participant")
-		return nil, err
+		return nil, 0, err
 	}
 	log = log.WithField("participants.name.display", participant.DisplayName)
-	log.Warn("Got participant details from extra-life")
+	log.Warn("Got participant details from donordrive")
 
 	cTeam := df.CachedParticipant{
 		Participant: *participant,
+		Platform:    platform,
 		FetchedAt:   time.Now().UTC(),
 	}
 	res, err := json.Marshal(&cTeam)
 	if err != nil {
 		log.WithError(err).Error("Problem marshaling participants team into json")
-		return nil, err
+		return nil, 0, err
+	}
+
+	ttl := sgc.dynamicTTL(ctx, log, GroupELParticipants, platform, participant.EventId, participant.StreamIsLive)
+	log.WithField("cache.ttl", ttl).Warn("Done")
+	return res, ttl, nil
+}
+
+func donationsGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, time.Duration, error) {
+	platform, participantID, err := parsePlatformIDKey(key)
+	if err != nil {
+		log.WithError(err).Info("Problem parsing platform and participant id from key")
+		return nil, 0, ErrInvalidKey
+	}
+	log = log.WithFields(logrus.Fields{
+		"participant.id":      participantID,
+		"donordrive.platform": platform,
+	})
+
+	client, err := ddrive.ForPlatform(platform)
+	if err != nil {
+		log.WithError(err).Error("Problem getting donordrive client")
+		return nil, 0, err
+	}
+
+	log.Warn("Going to fetch participant donations from donordrive")
+	donations, err := client.GetParticipantDonations(ctx, participantID)
+	if err != nil {
+		log.WithError(err).Error("Problem fetching participant donations")
+		return nil, 0, err
+	}
+	log = log.WithField("donations.count", len(donations))
+	log.Warn("Got participant donations from donordrive")
+
+	cDonations := df.CachedDonations{
+		Donations: donations,
+		Count:     len(donations),
+		Platform:  platform,
+		FetchedAt: time.Now().UTC(),
+	}
+	res, err := json.Marshal(&cDonations)
+	if err != nil {
+		log.WithError(err).Error("Problem marshaling participant donations into json")
+		return nil, 0, err
+	}
+
+	// Live and event come from the participant - fall back to the donations' event if we can't get it
+	eventID := 0
+	live := false
+	for _, d := range donations {
+		eventID = d.EventID
+	}
+	if data, err := sgc.Fetch(ctx, GroupELParticipants, key); err != nil {
+		log.WithError(err).Info("Problem getting participant for donations ttl")
+	} else {
+		cParticipant := df.CachedParticipant{}
+		if err := json.Unmarshal(data, &cParticipant); err != nil {
+			log.WithError(err).Info("Problem unmarshalling participant for donations ttl")
+		} else {
+			eventID = cParticipant.EventId
+			live = cParticipant.StreamIsLive
+		}
+	}
+	ttl := sgc.dynamicTTL(ctx, log, GroupELDonations, platform, eventID, live)
+	log.WithField("cache.ttl", ttl).Warn("Done")
+	return res, ttl, nil
+}
+
+func eventsGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, time.Duration, error) {
+	platform, id, err := ParsePlatformKey(key)
+	if err != nil || id != EventsKeyAll {
+		log.WithError(err).Info("Problem parsing platform events key")
+		return nil, 0, ErrInvalidKey
+	}
+	log = log.WithField("donordrive.platform", platform)
+
+	client, err := ddrive.ForPlatform(platform)
+	if err != nil {
+		log.WithError(err).Error("Problem getting donordrive client")
+		return nil, 0, err
+	}
+
+	log.Warn("Going to fetch events from donordrive")
+	events, err := client.GetEvents(ctx)
+	if err != nil {
+		log.WithError(err).Error("Problem fetching events")
+		return nil, 0, err
+	}
+	log = log.WithField("events.count", len(events))
+	log.Warn("Got events from donordrive")
+
+	cEvents := df.CachedEvents{
+		Events:    events,
+		Count:     len(events),
+		Platform:  platform,
+		FetchedAt: time.Now().UTC(),
+	}
+	res, err := json.Marshal(&cEvents)
+	if err != nil {
+		log.WithError(err).Error("Problem marshaling events into json")
+		return nil, 0, err
 	}
 	log.Warn("Done")
-	// FIXME: Dynamic timeout and/or viper based
-	return res, nil
+	return res, 0, nil
+}
+
+func eventGroup(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, time.Duration, error) {
+	platform, eventID, err := parsePlatformIDKey(key)
+	if err != nil {
+		log.WithError(err).Info("Problem parsing platform and event id from key")
+		return nil, 0, ErrInvalidKey
+	}
+	log = log.WithFields(logrus.Fields{
+		"event.id":            eventID,
+		"donordrive.platform": platform,
+	})
+
+	client, err := ddrive.ForPlatform(platform)
+	if err != nil {
+		log.WithError(err).Error("Problem getting donordrive client")
+		return nil, 0, err
+	}
+
+	log.Warn("Going to fetch event teams from donordrive")
+	teams, err := client.GetEventTeams(ctx, eventID)
+	if err != nil {
+		log.WithError(err).Error("Problem fetching event teams")
+		return nil, 0, err
+	}
+	log = log.WithField("teams.count", len(teams))
+	log.Warn("Got event teams from donordrive")
+
+	cEvent := df.CachedEvent{
+		EventID:   eventID,
+		Teams:     teams,
+		TeamCount: len(teams),
+		Platform:  platform,
+		FetchedAt: time.Now().UTC(),
+	}
+
+	// The event details only come from the list of all events - not fatal if we can't get them
+	event, err := sgc.findEvent(ctx, platform, eventID)
+	if err != nil {
+		log.WithError(err).Info("Problem getting event details")
+	}
+	cEvent.Event = event
+
+	res, err := json.Marshal(&cEvent)
+	if err != nil {
+		log.WithError(err).Error("Problem marshaling event into json")
+		return nil, 0, err
+	}
+
+	// Any live stream in the event counts as live
+	live := false
+	for _, t := range teams {
+		live = live || (t.StreamIsLive != nil && *t.StreamIsLive)
+	}
+	ttl := sgc.dynamicTTL(ctx, log, GroupELEvent, platform, eventID, live)
+	log.WithField("cache.ttl", ttl).Warn("Done")
+	return res, ttl, nil
+}
+
+//dynamicTTL picks the group's ttl based on if there's a live stream or if the event is over
+func (c *SharedGCache) dynamicTTL(ctx context.Context, log *logrus.Entry, groupName string, platform string, eventID int, live bool) time.Duration {
+	if live {
+		return GroupTTL(groupName, TTLLive)
+	}
+	if eventID == 0 {
+		return GroupTTL(groupName)
+	}
+
+	ended, err := c.eventEnded(ctx, platform, eventID)
+	if err != nil {
+		// Not a big deal - just use the default
+		log.WithError(err).Info("Problem checking if event has ended")
+		return GroupTTL(groupName)
+	}
+	if ended {
+		return GroupTTL(groupName, TTLClosed)
+	}
+	return GroupTTL(groupName)
+}
+
+//eventEnded checks if the given event's end date has passed on the platform - unknown events haven't ended
+func (c *SharedGCache) eventEnded(ctx context.Context, platform string, eventID int) (bool, error) {
+	event, err := c.findEvent(ctx, platform, eventID)
+	if err != nil || event == nil {
+		return false, err
+	}
+	return !event.EndDateUTC.IsZero() && event.EndDateUTC.Before(time.Now()), nil
+}
+
+//findEvent looks up the event in the platform's cached list of events - nil if it's not there
+func (c *SharedGCache) findEvent(ctx context.Context, platform string, eventID int) (*donordrive.Event, error) {
+	data, err := c.Fetch(ctx, GroupELEvents, PlatformKey(platform, EventsKeyAll))
+	if err != nil {
+		return nil, err
+	}
+
+	events := df.CachedEvents{}
+	if err := json.Unmarshal(data, &events); err != nil {
+		return nil, err
+	}
+
+	for i := range events.Events {
+		if events.Events[i].EventId == eventID {
+			return &events.Events[i], nil
+		}
+	}
+	return nil, nil
 }
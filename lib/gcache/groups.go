@@ -2,13 +2,22 @@ package gcache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/fragforce/fragevents/lib/tracing"
 	"github.com/mailgun/groupcache/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"strings"
 	"time"
 )
 
+const (
+	TTLLive   = "live"   // TTL variant for when there's a live stream
+	TTLClosed = "closed" // TTL variant for when the event is over
+)
+
 func init() {
 	doCheckInits()
 	viper.SetDefault("cache.stat.initial", time.Minute*10) // How long to wait before posting the first time
@@ -53,12 +62,18 @@ func (c *SharedGCache) initPendingGroupF() {
 }
 
 //registerGroupF called from init to create+register groupcache create functions
-func registerGroupF(groupName string, defaultCacheSizeMB int64, groupGetterF GroupGetterFunc) {
+func registerGroupF(groupName string, defaultCacheSizeMB int64, defaultTTL time.Duration, groupGetterF GroupGetterFunc) {
 	if defaultCacheSizeMB <= 0 {
 		defaultCacheSizeMB = 16
 	}
+	if defaultTTL <= 0 {
+		defaultTTL = time.Minute * 30
+	}
 	cacheSizeKey := fmt.Sprintf("group.%s.bytes", groupName)
 	viper.SetDefault(cacheSizeKey, 1024*1024*defaultCacheSizeMB)
+	viper.SetDefault(GroupTTLKey(groupName), defaultTTL)
+	viper.SetDefault(GroupTTLKey(groupName, TTLLive), time.Minute*2)
+	viper.SetDefault(GroupTTLKey(groupName, TTLClosed), time.Hour*6)
 
 	err := RegisterPendingGroup(func(log *logrus.Entry, sgc *SharedGCache) *groupcache.Group {
 		log = log.WithField("cache.size.bytes", viper.GetInt64(cacheSizeKey))
@@ -68,14 +83,64 @@ func registerGroupF(groupName string, defaultCacheSizeMB int64, groupGetterF Gro
 			viper.GetInt64(cacheSizeKey),
 			groupcache.GetterFunc(func(ctx context.Context, key string, dest groupcache.Sink) error {
 				log := log.WithField("groupcache.key", key)
+				ctx, span := tracing.Start(ctx, "gcache load",
+					attribute.String("gcache.group", groupName),
+					attribute.String("gcache.key", key),
+				)
+				defer span.End()
+				if viper.GetBool("cache.l2.enabled") {
+					res, ttl, err := sgc.l2Get(ctx, groupName, key)
+					if err == nil {
+						// Don't hold it in memory past the group's normal ttl just because l2 keeps things longer
+						if gTTL := GroupTTL(groupName); ttl > gTTL {
+							ttl = gTTL
+						}
+						log.WithField("cache.ttl", ttl).Trace("Using value from l2 cache")
+						return dest.SetBytes(compressValue(log, groupName, res), time.Now().Add(ttl))
+					}
+					if !errors.Is(err, ErrL2Miss) {
+						log.WithError(err).Warn("Problem reading l2 cache")
+					}
+				}
+
 				log.Trace("Running group getter")
-				res, err := groupGetterF(ctx, log, sgc, key)
-				if err != nil {
+				res, ttl, err := groupGetterF(ctx, log, sgc, key)
+				fresh := err == nil
+				if err != nil && isNotFoundErr(err) {
+					// Cache that it doesn't exist so we don't keep asking
+					log.WithError(err).Info("Origin says key doesn't exist - caching that")
+					res = notFoundMarker
+					ttl = viper.GetDuration("cache.notfound.ttl")
+				} else if err != nil {
 					log.WithError(err).Error("Problem running getter")
-					return err
+					if !viper.GetBool("cache.stale.enabled") {
+						return err
+					}
+					stale, sErr := sgc.loadStale(ctx, groupName, key)
+					if sErr != nil {
+						log.WithError(sErr).Info("No stale value to fall back to")
+						return err
+					}
+					log.Warn("Serving stale value and refreshing in the background")
+					go sgc.revalidate(log, groupName, key, groupGetterF)
+					res = stale
+					ttl = viper.GetDuration("cache.stale.ttl")
+				} else if viper.GetBool("cache.stale.enabled") {
+					if err := sgc.saveStale(ctx, groupName, key, res); err != nil {
+						log.WithError(err).Warn("Problem saving last good value")
+					}
+				}
+				if ttl <= 0 {
+					ttl = GroupTTL(groupName)
+				}
+				log = log.WithField("cache.ttl", ttl)
+				if fresh && viper.GetBool("cache.l2.enabled") {
+					if err := sgc.l2Set(ctx, groupName, key, res, ttl); err != nil {
+						log.WithError(err).Warn("Problem saving to l2 cache")
+					}
 				}
 				//grp := groupcache.GetGroup(groupName)
-				t := time.Now().Add(time.Minute * 30)
+				t := time.Now().Add(ttl)
 				//if err := grp.Set(ctx, key, res, t, true); err != nil {
 				//	log.WithError(err).Error("Problem updating cache")
 				//	return err
@@ -84,7 +149,7 @@ func registerGroupF(groupName string, defaultCacheSizeMB int64, groupGetterF Gro
 				//	log.WithError(err).Error("Problem updating cache")
 				//	return err
 				//}
-				if err := dest.SetBytes(res, t); err != nil {
+				if err := dest.SetBytes(compressValue(log, groupName, res), t); err != nil {
 					log.WithError(err).Error("Problem returning data")
 					return err
 				}
@@ -102,6 +167,20 @@ func registerGroupF(groupName string, defaultCacheSizeMB int64, groupGetterF Gro
 	}
 }
 
+//GroupTTLKey is the viper key for the group's ttl - variant is optional (e.g. TTLLive)
+// Variants use ttl-<variant> rather than ttl.<variant> as viper can't have a key that's also a parent of other keys
+func GroupTTLKey(groupName string, variant ...string) string {
+	if len(variant) == 0 {
+		return fmt.Sprintf("group.%s.ttl", groupName)
+	}
+	return fmt.Sprintf("group.%s.ttl-%s", groupName, strings.Join(variant, "-"))
+}
+
+//GroupTTL gets the configured ttl for the group - variant is optional (e.g. TTLLive)
+func GroupTTL(groupName string, variant ...string) time.Duration {
+	return viper.GetDuration(GroupTTLKey(groupName, variant...))
+}
+
 //logCacheStats gets run via go routine to run forever and log the groupcache.Group stats every x period
 func (c *SharedGCache) logCacheStats(log *logrus.Entry, group *groupcache.Group) {
 	sleepPeriod := viper.GetDuration("cache.stat.sleep")
@@ -0,0 +1,90 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var (
+	ErrL2Miss = errors.New("not in l2 cache")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("cache.l2.enabled", false)
+	viper.SetDefault("cache.l2.ttl", time.Duration(0))  // How long entries live in redis - 0 means the same as the entry's groupcache ttl
+	viper.SetDefault("cache.l2.timeout", time.Second*2) // Max time for l2 reads/writes - it's only an optimization
+	viper.SetDefault("cache.l2.compression", df.CompressGzip)
+}
+
+//l2Key is the redis key for the l2 copy of the group's key
+func l2Key(groupName string, key string) string {
+	return fmt.Sprintf("l2-%s-%s", groupName, key)
+}
+
+//l2Get fetches the key from the redis l2 cache along with how much longer it's valid for - ErrL2Miss if not there
+func (c *SharedGCache) l2Get(ctx context.Context, groupName string, key string) ([]byte, time.Duration, error) {
+	ctx, canc := context.WithTimeout(ctx, viper.GetDuration("cache.l2.timeout"))
+	defer canc()
+
+	rKey := l2Key(groupName, key)
+	pipe := c.rClient.Pipeline()
+	getCmd := pipe.Get(ctx, rKey)
+	ttlCmd := pipe.PTTL(ctx, rKey)
+	if _, err := pipe.Exec(ctx); errors.Is(err, redis.Nil) {
+		return nil, 0, ErrL2Miss
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	ttl := ttlCmd.Val()
+	if ttl <= 0 {
+		// Expired between the two commands or has no expiry somehow - don't trust it
+		return nil, 0, ErrL2Miss
+	}
+
+	data, err := df.Decompress([]byte(getCmd.Val()))
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, ttl, nil
+}
+
+//l2Set stores a compressed copy of the value in redis
+func (c *SharedGCache) l2Set(ctx context.Context, groupName string, key string, value []byte, ttl time.Duration) error {
+	if l2TTL := viper.GetDuration("cache.l2.ttl"); l2TTL > 0 {
+		ttl = l2TTL
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	data, err := df.Compress(viper.GetString("cache.l2.compression"), value)
+	if err != nil {
+		return err
+	}
+
+	ctx, canc := context.WithTimeout(ctx, viper.GetDuration("cache.l2.timeout"))
+	defer canc()
+	return c.rClient.Set(ctx, l2Key(groupName, key), data, ttl).Err()
+}
+
+//Evict removes the key from the named group on all peers plus from the l2 cache
+func (c *SharedGCache) Evict(ctx context.Context, groupName string, key string) error {
+	grp, err := c.GetGroupByName(groupName)
+	if err != nil {
+		return err
+	}
+	// Clear l2 first so a peer refilling groupcache can't pull the old value back out of it
+	if viper.GetBool("cache.l2.enabled") {
+		if err := c.rClient.Del(ctx, l2Key(groupName, key)).Err(); err != nil {
+			return err
+		}
+	}
+	return grp.Remove(ctx, key)
+}
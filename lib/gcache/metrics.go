@@ -0,0 +1,93 @@
+package gcache
+
+import (
+	"github.com/fragforce/fragevents/lib/metrics"
+	"github.com/mailgun/groupcache/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// groupCollector exports groupcache.Group stats - they're already counters so we just read them at scrape time
+type groupCollector struct {
+	counters map[string]*prometheus.Desc
+	caches   map[string]*prometheus.Desc
+}
+
+func init() {
+	doCheckInits()
+	prometheus.MustRegister(newGroupCollector())
+}
+
+func newGroupCollector() *groupCollector {
+	counter := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "groupcache", name), help, []string{"group"}, nil)
+	}
+	cache := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "groupcache", name), help, []string{"group", "cache"}, nil)
+	}
+	return &groupCollector{
+		counters: map[string]*prometheus.Desc{
+			"gets":            counter("gets_total", "Get requests, including from peers"),
+			"cache_hits":      counter("cache_hits_total", "Gets served from either the main or hot cache"),
+			"peer_loads":      counter("peer_loads_total", "Remote loads or remote cache hits"),
+			"peer_errors":     counter("peer_errors_total", "Errors loading from peers"),
+			"loads":           counter("loads_total", "Gets that weren't cache hits"),
+			"loads_deduped":   counter("loads_deduped_total", "Loads after singleflight dedupe"),
+			"local_loads":     counter("local_loads_total", "Loads done by our getter"),
+			"local_load_errs": counter("local_load_errors_total", "Errors from our getter"),
+			"server_requests": counter("server_requests_total", "Gets that came over the network from peers"),
+		},
+		caches: map[string]*prometheus.Desc{
+			"bytes":     cache("cache_bytes", "Bytes held in the cache"),
+			"items":     cache("cache_items", "Items held in the cache"),
+			"gets":      cache("cache_gets_total", "Gets against the cache"),
+			"hits":      cache("cache_hits_by_cache_total", "Hits in the cache"),
+			"evictions": cache("cache_evictions_total", "Evictions from the cache"),
+		},
+	}
+}
+
+func (gc *groupCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range gc.counters {
+		ch <- d
+	}
+	for _, d := range gc.caches {
+		ch <- d
+	}
+}
+
+func (gc *groupCollector) Collect(ch chan<- prometheus.Metric) {
+	c := GlobalCache()
+	if c == nil {
+		return
+	}
+	grps, err := c.GetAllGroups()
+	if err != nil {
+		return
+	}
+	for _, grp := range grps {
+		name := grp.Name()
+		stats := map[string]int64{
+			"gets":            grp.Stats.Gets.Get(),
+			"cache_hits":      grp.Stats.CacheHits.Get(),
+			"peer_loads":      grp.Stats.PeerLoads.Get(),
+			"peer_errors":     grp.Stats.PeerErrors.Get(),
+			"loads":           grp.Stats.Loads.Get(),
+			"loads_deduped":   grp.Stats.LoadsDeduped.Get(),
+			"local_loads":     grp.Stats.LocalLoads.Get(),
+			"local_load_errs": grp.Stats.LocalLoadErrs.Get(),
+			"server_requests": grp.Stats.ServerRequests.Get(),
+		}
+		for stat, v := range stats {
+			ch <- prometheus.MustNewConstMetric(gc.counters[stat], prometheus.CounterValue, float64(v), name)
+		}
+
+		for cName, cType := range map[string]groupcache.CacheType{"main": groupcache.MainCache, "hot": groupcache.HotCache} {
+			cs := grp.CacheStats(cType)
+			ch <- prometheus.MustNewConstMetric(gc.caches["bytes"], prometheus.GaugeValue, float64(cs.Bytes), name, cName)
+			ch <- prometheus.MustNewConstMetric(gc.caches["items"], prometheus.GaugeValue, float64(cs.Items), name, cName)
+			ch <- prometheus.MustNewConstMetric(gc.caches["gets"], prometheus.CounterValue, float64(cs.Gets), name, cName)
+			ch <- prometheus.MustNewConstMetric(gc.caches["hits"], prometheus.CounterValue, float64(cs.Hits), name, cName)
+			ch <- prometheus.MustNewConstMetric(gc.caches["evictions"], prometheus.CounterValue, float64(cs.Evictions), name, cName)
+		}
+	}
+}
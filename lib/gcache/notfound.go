@@ -0,0 +1,66 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/fragforce/fragevents/lib/ddrive"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/tracing"
+	"github.com/mailgun/groupcache/v2"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+var (
+	// notFoundMarker is cached in place of a value when the origin says it doesn't exist
+	notFoundMarker = []byte(`{"not-found":true}`)
+	ErrNotFound    = errors.New("not found")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("cache.notfound.ttl", time.Minute*2) // How long to remember that something doesn't exist
+}
+
+//isNotFoundErr checks if the getter error means the key doesn't exist, rather than a transient problem
+func isNotFoundErr(err error) bool {
+	return ddrive.IsNotFound(err) || errors.Is(err, ErrInvalidKey)
+}
+
+//Fetch gets the (decompressed) key from the named group - returns ErrNotFound if the origin said it doesn't exist
+func (c *SharedGCache) Fetch(ctx context.Context, groupName string, key string) (ret []byte, err error) {
+	ctx, span := tracing.Start(ctx, "gcache fetch",
+		attribute.String("gcache.group", groupName),
+		attribute.String("gcache.key", key),
+	)
+	defer func() {
+		// Not found is a normal answer, not a failure
+		if errors.Is(err, ErrNotFound) {
+			span.SetAttributes(attribute.Bool("gcache.not_found", true))
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	grp, err := c.GetGroupByName(groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	if err := grp.Get(ctx, key, groupcache.AllocatingByteSliceSink(&raw)); err != nil {
+		return nil, err
+	}
+	// Big values are stored compressed
+	data, err := df.Decompress(raw)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(data, notFoundMarker) {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
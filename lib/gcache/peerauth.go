@@ -0,0 +1,125 @@
+package gcache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderPeerTimestamp = "X-Groupcache-Timestamp"
+	HeaderPeerSignature = "X-Groupcache-Signature"
+)
+
+var (
+	ErrPeerAuthMissing   = errors.New("missing peer signature")
+	ErrPeerAuthExpired   = errors.New("peer request timestamp outside the allowed window")
+	ErrPeerAuthSignature = errors.New("bad peer signature")
+	ErrPeerAuthBody      = errors.New("peer request body too large")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("groupcache.token-previous", "")          // Old token still accepted during a rotation - empty to disable
+	viper.SetDefault("groupcache.auth.window", time.Second*30) // Max clock difference for a signed peer request
+	viper.SetDefault("groupcache.auth.body.max", 64*1024*1024) // Max bytes of a peer request body - it's read before it's verified
+}
+
+//peerSecrets are the secrets we accept - the first is the one we sign with
+func peerSecrets() []string {
+	ret := []string{viper.GetString("groupcache.token")}
+	if prev := viper.GetString("groupcache.token-previous"); prev != "" {
+		ret = append(ret, prev)
+	}
+	return ret
+}
+
+//peerSignature is the hex HMAC-SHA256 of the method, path, query, timestamp and body hash
+func peerSignature(secret string, req *http.Request, ts string, bodyHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", req.Method, req.URL.EscapedPath(), req.URL.RawQuery, ts, bodyHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//peerBodyHash is the hex SHA-256 of req's body, which is put back so it can still be read - max is the most bytes
+// to read, 0 for no limit
+func peerBodyHash(req *http.Request, max int64) (string, error) {
+	data := []byte{}
+	if req.Body != nil && req.Body != http.NoBody {
+		r := io.Reader(req.Body)
+		if max > 0 {
+			r = io.LimitReader(r, max+1)
+		}
+		var err error
+		if data, err = io.ReadAll(r); err != nil {
+			return "", err
+		}
+		_ = req.Body.Close()
+		if max > 0 && int64(len(data)) > max {
+			return "", ErrPeerAuthBody
+		}
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//signPeerRequest adds the timestamp and signature headers to req
+func signPeerRequest(req *http.Request, secret string, now time.Time) error {
+	bodyHash, err := peerBodyHash(req, 0)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(HeaderPeerTimestamp, ts)
+	req.Header.Set(HeaderPeerSignature, peerSignature(secret, req, ts, bodyHash))
+	return nil
+}
+
+//verifyPeerRequest checks the request was signed by one of secrets within the allowed window
+func verifyPeerRequest(req *http.Request, secrets []string, now time.Time) error {
+	tsStr := req.Header.Get(HeaderPeerTimestamp)
+	sig := req.Header.Get(HeaderPeerSignature)
+	if tsStr == "" || sig == "" {
+		return ErrPeerAuthMissing
+	}
+
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return ErrPeerAuthMissing
+	}
+	diff := now.Sub(time.Unix(ts, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > viper.GetDuration("groupcache.auth.window") {
+		return ErrPeerAuthExpired
+	}
+
+	given, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrPeerAuthSignature
+	}
+	bodyHash, err := peerBodyHash(req, viper.GetInt64("groupcache.auth.body.max"))
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets {
+		want, _ := hex.DecodeString(peerSignature(secret, req, tsStr, bodyHash))
+		if hmac.Equal(given, want) {
+			return nil
+		}
+	}
+	return ErrPeerAuthSignature
+}
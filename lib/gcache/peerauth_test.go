@@ -0,0 +1,121 @@
+package gcache
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPeerRequest(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		secret  string // Signed with - empty skips signing
+		signAt  time.Time
+		secrets []string // Accepted
+		mangle  func(req *http.Request)
+		err     error
+	}{
+		{name: "current secret", secret: "new", signAt: now, secrets: []string{"new", "old"}},
+		{name: "previous secret", secret: "old", signAt: now, secrets: []string{"new", "old"}},
+		{name: "unknown secret", secret: "other", signAt: now, secrets: []string{"new", "old"}, err: ErrPeerAuthSignature},
+		{name: "rotated out", secret: "old", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthSignature},
+		{name: "unsigned", secrets: []string{"new"}, err: ErrPeerAuthMissing},
+		{name: "in window", secret: "new", signAt: now.Add(-time.Second * 29), secrets: []string{"new"}},
+		{name: "clock ahead", secret: "new", signAt: now.Add(time.Second * 29), secrets: []string{"new"}},
+		{name: "too old", secret: "new", signAt: now.Add(-time.Minute), secrets: []string{"new"}, err: ErrPeerAuthExpired},
+		{name: "too far ahead", secret: "new", signAt: now.Add(time.Minute), secrets: []string{"new"}, err: ErrPeerAuthExpired},
+		{
+			name: "bad timestamp", secret: "new", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthMissing,
+			mangle: func(req *http.Request) { req.Header.Set(HeaderPeerTimestamp, "noon") },
+		},
+		{
+			name: "timestamp changed", secret: "new", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthSignature,
+			mangle: func(req *http.Request) { req.Header.Set(HeaderPeerTimestamp, "1893499201") },
+		},
+		{
+			name: "signature not hex", secret: "new", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthSignature,
+			mangle: func(req *http.Request) { req.Header.Set(HeaderPeerSignature, "zz") },
+		},
+		{
+			name: "body changed", secret: "new", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthSignature,
+			mangle: func(req *http.Request) { req.Body = io.NopCloser(strings.NewReader("poisoned")) },
+		},
+		{
+			name: "body dropped", secret: "new", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthSignature,
+			mangle: func(req *http.Request) { req.Body = http.NoBody },
+		},
+		{
+			name: "query changed", secret: "new", signAt: now, secrets: []string{"new"}, err: ErrPeerAuthSignature,
+			mangle: func(req *http.Request) { req.URL.RawQuery = "ttl=999999" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/_groupcache/EL-Team/el-1234?ttl=60", strings.NewReader("value"))
+			if tt.secret != "" {
+				if err := signPeerRequest(req, tt.secret, tt.signAt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.mangle != nil {
+				tt.mangle(req)
+			}
+			if err := verifyPeerRequest(req, tt.secrets, now); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+			// Still readable by the handler
+			if tt.err == nil {
+				if body, _ := io.ReadAll(req.Body); string(body) != "value" {
+					t.Errorf("got body %q after verifying", body)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyPeerRequestCoversRequest(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{name: "other path", method: "GET", path: "/_groupcache/EL-Team/el-9999"},
+		{name: "other method", method: "PUT", path: "/_groupcache/EL-Team/el-1234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed := httptest.NewRequest("GET", "/_groupcache/EL-Team/el-1234", nil)
+			if err := signPeerRequest(signed, "new", now); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header = signed.Header.Clone()
+			if err := verifyPeerRequest(req, []string{"new"}, now); !errors.Is(err, ErrPeerAuthSignature) {
+				t.Errorf("got %v, want %v", err, ErrPeerAuthSignature)
+			}
+		})
+	}
+}
+
+func TestVerifyPeerRequestBodyMax(t *testing.T) {
+	old := viper.GetInt64("groupcache.auth.body.max")
+	viper.Set("groupcache.auth.body.max", 4)
+	t.Cleanup(func() { viper.Set("groupcache.auth.body.max", old) })
+
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	req := httptest.NewRequest("PUT", "/_groupcache/EL-Team/el-1234", strings.NewReader("too long"))
+	if err := signPeerRequest(req, "new", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPeerRequest(req, []string{"new"}, now); !errors.Is(err, ErrPeerAuthBody) {
+		t.Errorf("got %v, want %v", err, ErrPeerAuthBody)
+	}
+}
@@ -0,0 +1,93 @@
+package gcache
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+const (
+	PeerJoined = "joined"
+	PeerLeft   = "left"
+)
+
+// PeerEvent is a change in the groupcache peer list as seen by this node
+type PeerEvent struct {
+	Time   time.Time `json:"time"`
+	Peer   string    `json:"peer"`
+	Change string    `json:"change"` // PeerJoined or PeerLeft
+}
+
+// peerTracker remembers the current peer list plus recent changes to it
+type peerTracker struct {
+	lock   *sync.Mutex
+	peers  []string
+	events []PeerEvent
+}
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("groupcache.peers.events.max", 50) // How many peer change events to keep
+}
+
+func newPeerTracker() *peerTracker {
+	return &peerTracker{
+		lock:   &sync.Mutex{},
+		peers:  make([]string, 0),
+		events: make([]PeerEvent, 0),
+	}
+}
+
+//update swaps in the new peer list and records/logs anything that joined or left
+func (t *peerTracker) update(log *logrus.Entry, peers []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	oldPeers := make(map[string]bool)
+	for _, peer := range t.peers {
+		oldPeers[peer] = true
+	}
+	newPeers := make(map[string]bool)
+	for _, peer := range peers {
+		newPeers[peer] = true
+		if !oldPeers[peer] {
+			t.record(log, PeerEvent{Time: now, Peer: peer, Change: PeerJoined})
+		}
+	}
+	for _, peer := range t.peers {
+		if !newPeers[peer] {
+			t.record(log, PeerEvent{Time: now, Peer: peer, Change: PeerLeft})
+		}
+	}
+
+	t.peers = append(make([]string, 0, len(peers)), peers...)
+}
+
+//record adds the event, dropping the oldest if we're over the limit - lock must be held
+func (t *peerTracker) record(log *logrus.Entry, event PeerEvent) {
+	log.WithFields(logrus.Fields{
+		"peer.uri":    event.Peer,
+		"peer.change": event.Change,
+	}).Info("Groupcache peer list changed")
+
+	t.events = append(t.events, event)
+	if max := viper.GetInt("groupcache.peers.events.max"); len(t.events) > max {
+		t.events = t.events[len(t.events)-max:]
+	}
+}
+
+//CurrentPeers is the peer list as of the last update
+func (c *SharedGCache) CurrentPeers() []string {
+	c.peers.lock.Lock()
+	defer c.peers.lock.Unlock()
+	return append(make([]string, 0, len(c.peers.peers)), c.peers.peers...)
+}
+
+//PeerEvents lists recent peer changes, oldest first
+func (c *SharedGCache) PeerEvents() []PeerEvent {
+	c.peers.lock.Lock()
+	defer c.peers.lock.Unlock()
+	return append(make([]PeerEvent, 0, len(c.peers.events)), c.peers.events...)
+}
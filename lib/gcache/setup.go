@@ -8,21 +8,28 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"sync"
+	"time"
 )
 
 type SharedGCache struct {
-	lock      *sync.Mutex
-	log       *logrus.Entry
-	pool      *groupcache.HTTPPool
-	myURI     string
-	myAddr    string
-	myPort    int
-	rClient   *redis.Client
-	peerDebug bool
+	lock         *sync.Mutex
+	log          *logrus.Entry
+	pool         *groupcache.HTTPPool
+	myURI        string
+	myAddr       string
+	myPort       int
+	rClient      *redis.Client
+	peerDebug    bool
+	revalidating *sync.Map // Keys with a background refresh running
+	discovery    PeerDiscovery
+	listenPort   int
+	peers        *peerTracker
+	warm         *warmStatus
 }
 
 type GroupFunc func(log *logrus.Entry, sgc *SharedGCache) *groupcache.Group
-type GroupGetterFunc func(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) ([]byte, error)
+// GroupGetterFunc fetches the value for key - a zero ttl means use the group's default ttl
+type GroupGetterFunc func(ctx context.Context, log *logrus.Entry, sgc *SharedGCache, key string) (value []byte, ttl time.Duration, err error)
 
 var (
 	cache                      *SharedGCache
@@ -35,10 +42,12 @@ var (
 	ErrPendingGroupsCreated    = errors.New("pending groups already created")
 	ErrPendingGroupsNotCreated = errors.New("pending groups not created yet")
 	ErrNoSuchGroup             = errors.New("requested group doesn't exist")
+	ErrInvalidKey              = errors.New("invalid key for group")
 )
 
 func init() {
 	doCheckInits()
+	viper.SetDefault("groupcache.port", 0) // Port the groupcache listener binds to - 0 means port+1
 }
 
 //doCheckInits runs various local inits that need to run before others can do stuff - Safe to rerun many times
@@ -68,11 +77,25 @@ func doCheckInits() {
 
 func NewSharedGCache(log *logrus.Entry, rClient *redis.Client) (*SharedGCache, error) {
 	ret := SharedGCache{
-		lock:      &sync.Mutex{},
-		log:       log,
-		rClient:   rClient,
-		peerDebug: viper.GetBool("debug.peers") && viper.GetBool("debug"),
+		lock:         &sync.Mutex{},
+		log:          log,
+		rClient:      rClient,
+		peerDebug:    viper.GetBool("debug.peers") && viper.GetBool("debug"),
+		revalidating: &sync.Map{},
+		listenPort:   viper.GetInt("groupcache.port"),
+		peers:        newPeerTracker(),
+		warm:         newWarmStatus(),
 	}
+	if ret.listenPort == 0 {
+		ret.listenPort = viper.GetInt("port") + 1
+	}
+
+	discovery, err := NewPeerDiscovery(rClient, ret.listenPort)
+	if err != nil {
+		log.WithError(err).Error("Problem setting up groupcache peer discovery")
+		return nil, err
+	}
+	ret.discovery = discovery
 
 	// Init gcache pool
 	if err := ret.createPool(); err != nil {
@@ -0,0 +1,116 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"time"
+)
+
+var (
+	ErrNoStale = errors.New("no stale value saved")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("cache.stale.enabled", true)
+	viper.SetDefault("cache.stale.retention", time.Hour*24*7)       // How long to keep the last good value around
+	viper.SetDefault("cache.stale.ttl", time.Minute*1)              // How long to cache a stale value in groupcache
+	viper.SetDefault("cache.stale.backoff.initial", time.Second*5)  // First wait between background refreshes
+	viper.SetDefault("cache.stale.backoff.max", time.Minute*5)      // Longest wait between background refreshes
+	viper.SetDefault("cache.stale.backoff.attempts", 10)            // Give up on the background refresh after this many tries
+	viper.SetDefault("cache.stale.timeout", time.Second*5)          // Max time for stale reads/writes to redis
+	viper.SetDefault("cache.stale.refresh.timeout", time.Second*30) // Max time for a single background refresh
+}
+
+//staleKey is the redis key holding the last good value
+func staleKey(groupName string, key string) string {
+	return fmt.Sprintf("stale-%s-%s", groupName, key)
+}
+
+//saveStale keeps the last good value around in case the origin fails later
+func (c *SharedGCache) saveStale(ctx context.Context, groupName string, key string, value []byte) error {
+	ctx, canc := context.WithTimeout(ctx, viper.GetDuration("cache.stale.timeout"))
+	defer canc()
+	return c.rClient.Set(ctx, staleKey(groupName, key), value, viper.GetDuration("cache.stale.retention")).Err()
+}
+
+//loadStale fetches the last good value - ErrNoStale if we don't have one
+func (c *SharedGCache) loadStale(ctx context.Context, groupName string, key string) ([]byte, error) {
+	ctx, canc := context.WithTimeout(ctx, viper.GetDuration("cache.stale.timeout"))
+	defer canc()
+	data, err := c.rClient.Get(ctx, staleKey(groupName, key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNoStale
+	}
+	if err != nil {
+		return nil, err
+	}
+	return df.MarkStale(data)
+}
+
+//revalidate keeps retrying the getter in the background until it works, then updates the cache
+func (c *SharedGCache) revalidate(log *logrus.Entry, groupName string, key string, getter GroupGetterFunc) {
+	rKey := staleKey(groupName, key)
+	if _, running := c.revalidating.LoadOrStore(rKey, true); running {
+		return
+	}
+	defer c.revalidating.Delete(rKey)
+
+	wait := viper.GetDuration("cache.stale.backoff.initial")
+	for i := 0; i < viper.GetInt("cache.stale.backoff.attempts"); i++ {
+		log := log.WithFields(logrus.Fields{
+			"revalidate.attempt": i,
+			"revalidate.wait":    wait,
+		})
+		time.Sleep(wait)
+		if wait *= 2; wait > viper.GetDuration("cache.stale.backoff.max") {
+			wait = viper.GetDuration("cache.stale.backoff.max")
+		}
+
+		ctx, canc := context.WithTimeout(context.Background(), viper.GetDuration("cache.stale.refresh.timeout"))
+		res, ttl, err := getter(ctx, log, c, key)
+		if err != nil && isNotFoundErr(err) {
+			// Gone for good - leave the stale value to expire on its own
+			canc()
+			log.WithError(err).Info("Origin says key doesn't exist anymore - stopping background refresh")
+			return
+		}
+		if err != nil {
+			canc()
+			log.WithError(err).Info("Background refresh failed - will retry")
+			continue
+		}
+		if ttl <= 0 {
+			ttl = GroupTTL(groupName)
+		}
+
+		if err := c.saveStale(ctx, groupName, key, res); err != nil {
+			log.WithError(err).Warn("Problem saving last good value")
+		}
+		if viper.GetBool("cache.l2.enabled") {
+			if err := c.l2Set(ctx, groupName, key, res, ttl); err != nil {
+				log.WithError(err).Warn("Problem saving to l2 cache")
+			}
+		}
+
+		grp, err := c.GetGroupByName(groupName)
+		if err != nil {
+			canc()
+			log.WithError(err).Error("Problem getting group for background refresh")
+			return
+		}
+		if err := grp.Set(ctx, key, compressValue(log, groupName, res), time.Now().Add(ttl), false); err != nil {
+			log.WithError(err).Warn("Problem updating cache after background refresh")
+		}
+		canc()
+
+		log.Info("Background refresh worked")
+		return
+	}
+	log.Warn("Gave up on background refresh")
+}
@@ -4,48 +4,84 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/handler_global"
+	"github.com/fragforce/fragevents/lib/tracing"
 	"github.com/fragforce/fragevents/lib/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/mailgun/groupcache/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net"
 	"net/http"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	InsecureToken = "INSECURE"
-	TokenKey      = "Authorization"
 )
 
+// SecuredHeaderTransport signs each peer request with Secret
 type SecuredHeaderTransport struct {
 	http.RoundTripper
-	Token string
-	Ctx   context.Context
+	Secret string
+	Ctx    context.Context
 }
 
 var (
-	ErrBadStatusCode = errors.New("bad status code returned")
+	ErrBadStatusCode    = errors.New("bad status code returned")
+	ErrPeersUnreachable = errors.New("peers unreachable")
+	ErrNotStarted       = errors.New("groupcache not started yet")
 )
 
 func init() {
 	doCheckInits()
 	viper.SetDefault("groupcache.token", InsecureToken)
-	viper.SetDefault("groupcache.peers.key", "peers")
+	viper.SetDefault("groupcache.peers.key", "peer-leases")
+	viper.SetDefault("groupcache.peers.lease", time.Second*30) // Peers that haven't renewed in this long are ignored
+	viper.SetDefault("groupcache.peers.renew", time.Second*10) // How often we renew our own lease
 	viper.SetDefault("groupcache.peer.update", time.Second*10)
 	viper.SetDefault("groupcache.wan.timeout", time.Second*5)
-	viper.SetDefault("peer.failed.sleep", time.Second*5) // How long to sleep between peer checks
-	viper.SetDefault("peer.failed.count", 3)             // How many checks must fail before a peer is removed
+	viper.SetDefault("groupcache.advertise.addr", "") // Host/IP other peers use to reach us - defaults to the heroku dyno dns name
+	viper.SetDefault("groupcache.advertise.port", 0)  // Port other peers use to reach us - 0 means our listen port
+	viper.SetDefault("peer.check.timeout", time.Second*5)
+	df.RegisterReadyCheck("groupcache-peers", false, func(ctx context.Context) (string, error) {
+		c := GlobalCache()
+		if c == nil {
+			return "", ErrNotStarted
+		}
+		return c.peersReadyCheck(ctx)
+	})
 }
 
 func (ct *SecuredHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Add(TokenKey, fmt.Sprintf("Bearer %s", ct.Token))
 	ctx, canc := context.WithTimeout(ct.Ctx, time.Second*3)
 	defer canc()
-	return ct.RoundTripper.RoundTrip(req.WithContext(ctx))
+	ctx, span := tracing.Tracer().Start(ctx, "groupcache peer "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.url", req.URL.String())),
+	)
+
+	// Don't modify the caller's request
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if err := signPeerRequest(req, ct.Secret, time.Now()); err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	res, err := ct.RoundTripper.RoundTrip(req)
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	}
+	tracing.End(span, err)
+	return res, err
 }
 
 //GetPool returns pool to register to "/_groupcache/" web handler
@@ -59,17 +95,36 @@ func (c *SharedGCache) createPool() error {
 	//ctx, canc := context.WithTimeout(context.Background(), viper.GetDuration("groupcache.wan.timeout"))
 	//defer canc()
 	//myIP, err := utils.GetExternalIP(ctx)
-	myIP, err := utils.GetLocalNodeFQDN()
-	if err != nil {
-		log.WithError(err).Error("Problem getting interface ip")
-		return err
+	myIP := viper.GetString("groupcache.advertise.addr")
+	if myIP == "" {
+		var err error
+		myIP, err = utils.GetLocalNodeFQDN()
+		if err != nil {
+			log.WithError(err).Error("Problem getting interface ip")
+			return err
+		}
 	}
 	log = log.WithField("my.ip", myIP)
 
-	myPort := viper.GetInt("port") + 1
+	myPort := viper.GetInt("groupcache.advertise.port")
+	if myPort == 0 {
+		myPort = c.listenPort
+	}
 	log = log.WithField("my.port", myPort)
 
-	myURI := fmt.Sprintf("http://%s:%d", myIP, myPort)
+	myURI := fmt.Sprintf("http://%s", net.JoinHostPort(myIP, fmt.Sprintf("%d", myPort)))
+	// Other peers have to list us the same way we list ourselves or groupcache sees two of us
+	if n, ok := c.discovery.(selfNormaliser); ok {
+		ctx, canc := context.WithTimeout(context.Background(), viper.GetDuration("peer.check.timeout"))
+		uri, err := n.SelfURI(ctx, myIP, myPort)
+		canc()
+		if err != nil {
+			log.WithError(err).Error("Problem working out our uri as discovery lists it")
+			return err
+		}
+		log = log.WithField("my.advertised.uri", myURI)
+		myURI = uri
+	}
 	log = log.WithField("my.uri", myURI)
 
 	log.Trace("Have my uri built")
@@ -78,8 +133,7 @@ func (c *SharedGCache) createPool() error {
 	if err != nil {
 		log.WithError(err).Warn("Problem fetching peers")
 	}
-	// Make sure we're first - Not really sure if it matters
-	peers = append([]string{myURI}, peers...)
+	peers = addSelf(myURI, peers)
 
 	pool := groupcache.NewHTTPPoolOpts(myURI, &groupcache.HTTPPoolOptions{
 		Transport: func(ctx context.Context) http.RoundTripper {
@@ -90,16 +144,14 @@ func (c *SharedGCache) createPool() error {
 
 			return &SecuredHeaderTransport{
 				RoundTripper: http.DefaultTransport,
-				Token:        viper.GetString("groupcache.token"),
+				Secret:       viper.GetString("groupcache.token"),
 				Ctx:          ctx,
 			}
 		},
 	})
 
-	// Keep in same order
-	sort.Strings(peers)
-
 	pool.Set(peers...)
+	c.peers.update(log, peers)
 
 	// Critical portion
 	c.lock.Lock()
@@ -133,9 +185,11 @@ func (c *SharedGCache) doPeerUpdateLoop() {
 		if c.peerDebug {
 			log.Trace("Updating peer list")
 		}
+		peers = c.withSelf(peers)
 		c.lock.Lock()
 		c.pool.Set(peers...)
 		c.lock.Unlock()
+		c.peers.update(log, peers)
 		if c.peerDebug {
 			log.Trace("Updated peer list")
 		}
@@ -144,80 +198,101 @@ func (c *SharedGCache) doPeerUpdateLoop() {
 	}
 }
 
+//withSelf makes sure we're in the peer list - not all discovery methods include us
+func (c *SharedGCache) withSelf(peers []string) []string {
+	return addSelf(c.myURI, peers)
+}
+
+//addSelf adds our uri to the peers and drops any dupes - sorted so every peer has them in the same order
+func addSelf(myURI string, peers []string) []string {
+	seen := map[string]bool{myURI: true}
+	ret := append(make([]string, 0, len(peers)+1), myURI)
+	for _, peer := range peers {
+		if seen[peer] {
+			continue
+		}
+		seen[peer] = true
+		ret = append(ret, peer)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
 func (c *SharedGCache) FetchPeers() ([]string, error) {
 	log := c.log.WithFields(logrus.Fields{
-		"peers.key":    viper.GetString("groupcache.peers.key"),
-		"peers.my.uri": c.myURI,
+		"peers.discovery": c.discovery.Name(),
+		"peers.my.uri":    c.myURI,
 	})
-	res, err := c.rClient.SMembers(context.Background(), viper.GetString("groupcache.peers.key")).Result()
+	res, err := c.listPeers(context.Background())
 	if err != nil {
 		log.WithError(err).Error("Problem fetching the groupcache peer list")
 		return res, err
 	}
-	log = log.WithField("peers.pre", res)
 	if c.peerDebug {
-		log.Trace("Fetched the groupcache peer list from redis")
-	}
-
-	// Won't help current but will help next time
-	for _, peer := range res {
-		go c.checkPeer(log, peer)
+		log.WithField("peers", res).Trace("Fetched the groupcache peer list")
 	}
-
+	sort.Strings(res)
 	return res, nil
 }
 
-//checkPeer checks if the given peer is up. If not, removes it from redis.
-func (c *SharedGCache) checkPeer(log *logrus.Entry, uri string) {
-	log = log.WithField("peer.uri", uri)
-	if c.peerDebug {
-		log.Trace("Going to check peer")
-	}
+//listPeers fetches the peer list without checking the peers
+func (c *SharedGCache) listPeers(ctx context.Context) ([]string, error) {
+	return c.discovery.Peers(ctx)
+}
 
-	for i := 0; i < viper.GetInt("peer.failed.count"); i++ {
-		log := log.WithField("check.number", i)
-		ok, err := c.checkPeerStatus(log, uri)
-		if err != nil {
-			log = log.WithError(err)
-		}
-		log = log.WithField("status.ok", ok)
+//peersReadyCheck is used by the readiness endpoint to see which of our peers are reachable
+func (c *SharedGCache) peersReadyCheck(ctx context.Context) (string, error) {
+	log := c.log
+	peers, err := c.listPeers(ctx)
+	if err != nil {
+		return "", err
+	}
 
-		if err == nil && ok {
-			log.Trace("Peer is ok!")
-			return
+	unreachable := make([]string, 0)
+	uLock := &sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, peer := range peers {
+		if peer == c.myURI {
+			continue
 		}
-		if c.peerDebug {
-			log.Trace("Peer not responding this time")
-		}
-		time.Sleep(viper.GetDuration("peer.failed.sleep"))
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if ok, err := c.checkPeerStatus(ctx, log, peer); err != nil || !ok {
+				uLock.Lock()
+				unreachable = append(unreachable, peer)
+				uLock.Unlock()
+			}
+		}(peer)
 	}
+	wg.Wait()
 
-	if c.peerDebug {
-		log.Debug("Removing peer from peer list in redis")
-	}
-	if err := c.removePeer(uri); err != nil {
-		log.WithError(err).Info("Error removing peer from peer list in redis")
+	detail := fmt.Sprintf("%d of %d peers reachable", len(peers)-len(unreachable), len(peers))
+	if len(unreachable) > 0 {
+		sort.Strings(unreachable)
+		return detail, fmt.Errorf("%w: %s", ErrPeersUnreachable, strings.Join(unreachable, ", "))
 	}
-	return
+	return detail, nil
 }
 
-//checkPeer checks if the given peer is up. If not, removes it from redis.
-func (c *SharedGCache) checkPeerStatus(log *logrus.Entry, uri string) (bool, error) {
+//checkPeerStatus checks if the given peer is up
+func (c *SharedGCache) checkPeerStatus(ctx context.Context, log *logrus.Entry, uri string) (bool, error) {
 	log = log.WithField("peer.uri", uri)
 	if c.peerDebug {
 		log.Trace("Going to check peer")
 	}
 
 	client := http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/alive", uri), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/alive", uri), nil)
 	if err != nil {
 		log.WithError(err).Error("Problem creating request")
 		return false, err
 	}
 
-	req.Header = http.Header{
-		"Content-Type":  {"application/json"},
-		"Authorization": {fmt.Sprintf("Bearer %s", viper.GetString("groupcache.token"))},
+	req.Header.Set("Content-Type", "application/json")
+	if err := signPeerRequest(req, viper.GetString("groupcache.token"), time.Now()); err != nil {
+		log.WithError(err).Error("Problem signing request")
+		return false, err
 	}
 
 	res, err := client.Do(req)
@@ -225,6 +300,7 @@ func (c *SharedGCache) checkPeerStatus(log *logrus.Entry, uri string) (bool, err
 		log.WithError(err).Info("Problem running request")
 		return false, err
 	}
+	defer res.Body.Close()
 	if res.StatusCode == 200 {
 		if c.peerDebug {
 			log.Trace("Done checking peer - it's ok")
@@ -235,19 +311,19 @@ func (c *SharedGCache) checkPeerStatus(log *logrus.Entry, uri string) (bool, err
 	return false, ErrBadStatusCode
 }
 
-//removeMyPeer removes ourselves from the redis based peer list
+//removeMyPeer removes ourselves from the peer list
 func (c *SharedGCache) removeMyPeer() error {
 	return c.removePeer(c.myURI)
 }
 
-//removePeer removes a peer from the redis based peer list
+//removePeer removes a peer from the peer list
 func (c *SharedGCache) removePeer(peerURI string) error {
 	log := c.log.WithFields(logrus.Fields{
-		"peers.key": viper.GetString("groupcache.peers.key"),
-		"peers.uri": peerURI,
+		"peers.discovery": c.discovery.Name(),
+		"peers.uri":       peerURI,
 	})
-	if _, err := c.rClient.SRem(context.Background(), viper.GetString("groupcache.peers.key"), peerURI).Result(); err != nil {
-		log.WithError(err).Error("Problem removing ourself from the groupcache peer list")
+	if err := c.discovery.Remove(context.Background(), peerURI); err != nil {
+		log.WithError(err).Error("Problem removing peer from the groupcache peer list")
 		return err
 	}
 	if c.peerDebug {
@@ -256,14 +332,14 @@ func (c *SharedGCache) removePeer(peerURI string) error {
 	return nil
 }
 
-//addMyPeer adds ourselves to the redis based peer list
+//addMyPeer adds ourselves to the peer list
 func (c *SharedGCache) addMyPeer() error {
 	log := c.log.WithFields(logrus.Fields{
-		"peers.key":    viper.GetString("groupcache.peers.key"),
-		"peers.my.uri": c.myURI,
+		"peers.discovery": c.discovery.Name(),
+		"peers.my.uri":    c.myURI,
 	})
 
-	if _, err := c.rClient.SAdd(context.Background(), viper.GetString("groupcache.peers.key"), c.myURI).Result(); err != nil {
+	if err := c.discovery.Register(context.Background(), c.myURI); err != nil {
 		log.WithError(err).Error("Problem adding ourself to groupcache peer list")
 		return err
 	}
@@ -273,6 +349,16 @@ func (c *SharedGCache) addMyPeer() error {
 	return nil
 }
 
+//doLeaseRenewLoop keeps our peer lease alive - no-op renewals for discovery methods without leases
+func (c *SharedGCache) doLeaseRenewLoop() {
+	for {
+		time.Sleep(viper.GetDuration("groupcache.peers.renew"))
+		if err := c.addMyPeer(); err != nil {
+			c.log.WithError(err).Warn("Problem renewing our groupcache peer lease")
+		}
+	}
+}
+
 //Shutdown our groupcache
 func (c *SharedGCache) Shutdown() error {
 	return c.removeMyPeer()
@@ -300,9 +386,14 @@ func (c *SharedGCache) StartRunPrep() error {
 		log.WithError(err).Error("Problem adding myself to peer list")
 		return err
 	}
+	go c.doLeaseRenewLoop()
+
+	if viper.GetBool("cache.warm.enabled") {
+		go c.doWarm()
+	}
 
 	go func() {
-		if err := ginEngine.Run(fmt.Sprintf("%s:%d", viper.GetString("listen"), viper.GetInt("port")+1)); err != nil {
+		if err := ginEngine.Run(fmt.Sprintf("%s:%d", viper.GetString("listen"), c.listenPort)); err != nil {
 			log.WithError(err).Fatal("Problem running GIN")
 		}
 	}()
@@ -312,14 +403,17 @@ func (c *SharedGCache) StartRunPrep() error {
 
 //GroupCacheHandler register via gin to "/_groupcache/"
 func (c *SharedGCache) GroupCacheHandler(ctx *gin.Context) {
-	if strings.TrimLeft(ctx.GetHeader(TokenKey), "Bearer ") != viper.GetString("groupcache.token") {
+	if err := verifyPeerRequest(ctx.Request, peerSecrets(), time.Now()); err != nil {
+		c.log.WithError(err).WithFields(logrus.Fields{
+			"peer.remote": ctx.ClientIP(),
+			"peer.path":   ctx.Request.URL.Path,
+		}).Warn("Rejected groupcache peer request")
 		// FIXME: Standardize error json
-		// FIXME: Add logging
-
 		ctx.AbortWithStatusJSON(http.StatusForbidden, map[string]string{
 			"status": "error",
 			"error":  "Forbidden",
 		})
+		return
 	}
 
 	pool := c.GetPool()
@@ -0,0 +1,198 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+const (
+	WarmStatePending = "pending"
+	WarmStateRunning = "running"
+	WarmStateDone    = "done"
+	WarmStateOff     = "disabled"
+)
+
+// WarmKey is a single group+key to pre-fetch
+type WarmKey struct {
+	Group string
+	Key   string
+}
+
+// WarmerFunc lists keys worth having in the cache at startup - lets other packages feed us without an import cycle
+type WarmerFunc func(ctx context.Context) ([]WarmKey, error)
+
+// warmStatus is the progress of the startup warm-up
+type warmStatus struct {
+	lock    *sync.Mutex
+	state   string
+	total   int // Keys this node owns
+	done    int
+	failed  int
+	skipped int // Keys owned by other peers
+}
+
+var (
+	warmers     map[string]WarmerFunc
+	wLock       = &sync.Mutex{}
+	ErrWarming  = errors.New("cache warm-up still running")
+	ErrWarmFail = errors.New("cache warm-up had failures")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("cache.warm.enabled", true)
+	viper.SetDefault("cache.warm.delay", time.Second*15)  // Give the peer list time to settle before working out what we own
+	viper.SetDefault("cache.warm.concurrency", 4)         // Max parallel fetches during warm-up
+	viper.SetDefault("cache.warm.timeout", time.Minute*5) // Max time for the whole warm-up
+	df.RegisterReadyCheck("groupcache-warm", false, func(ctx context.Context) (string, error) {
+		c := GlobalCache()
+		if c == nil {
+			return "", ErrNotStarted
+		}
+		return c.warmReadyCheck()
+	})
+}
+
+//RegisterWarmer adds a source of keys for the startup warm-up
+func RegisterWarmer(name string, f WarmerFunc) {
+	wLock.Lock()
+	defer wLock.Unlock()
+	if warmers == nil {
+		warmers = make(map[string]WarmerFunc)
+	}
+	warmers[name] = f
+}
+
+func newWarmStatus() *warmStatus {
+	state := WarmStatePending
+	if !viper.GetBool("cache.warm.enabled") {
+		state = WarmStateOff
+	}
+	return &warmStatus{
+		lock:  &sync.Mutex{},
+		state: state,
+	}
+}
+
+//OwnsKey checks if this node is the owner of key in the current peer list
+func (c *SharedGCache) OwnsKey(key string) bool {
+	_, remote := c.GetPool().PickPeer(key)
+	return !remote
+}
+
+//warmReadyCheck reports the warm-up progress
+func (c *SharedGCache) warmReadyCheck() (string, error) {
+	c.warm.lock.Lock()
+	defer c.warm.lock.Unlock()
+
+	detail := fmt.Sprintf("%s: %d of %d owned keys warmed, %d failed, %d owned by other peers", c.warm.state, c.warm.done, c.warm.total, c.warm.failed, c.warm.skipped)
+	switch {
+	case c.warm.state == WarmStatePending || c.warm.state == WarmStateRunning:
+		return detail, ErrWarming
+	case c.warm.failed > 0:
+		return detail, ErrWarmFail
+	}
+	return detail, nil
+}
+
+//collectWarmKeys runs all the warmers - a failing warmer is logged and skipped
+func collectWarmKeys(ctx context.Context, log *logrus.Entry) []WarmKey {
+	wLock.Lock()
+	toRun := make(map[string]WarmerFunc, len(warmers))
+	for name, f := range warmers {
+		toRun[name] = f
+	}
+	wLock.Unlock()
+
+	ret := make([]WarmKey, 0)
+	for name, f := range toRun {
+		log := log.WithField("warmer.name", name)
+		keys, err := f(ctx)
+		if err != nil {
+			log.WithError(err).Warn("Problem getting keys to warm")
+			continue
+		}
+		log.WithField("warmer.keys", len(keys)).Debug("Got keys to warm")
+		ret = append(ret, keys...)
+	}
+	return ret
+}
+
+//doWarm pre-fetches the keys we own so the first cron cycle doesn't stampede DonorDrive
+func (c *SharedGCache) doWarm() {
+	log := c.log.WithField("warm", true)
+
+	time.Sleep(viper.GetDuration("cache.warm.delay"))
+
+	ctx, canc := context.WithTimeout(context.Background(), viper.GetDuration("cache.warm.timeout"))
+	defer canc()
+
+	c.warm.lock.Lock()
+	c.warm.state = WarmStateRunning
+	c.warm.lock.Unlock()
+
+	owned := make([]WarmKey, 0)
+	skipped := 0
+	for _, wk := range collectWarmKeys(ctx, log) {
+		if c.OwnsKey(wk.Key) {
+			owned = append(owned, wk)
+		} else {
+			skipped++
+		}
+	}
+
+	c.warm.lock.Lock()
+	c.warm.total = len(owned)
+	c.warm.skipped = skipped
+	c.warm.lock.Unlock()
+	log = log.WithField("warm.owned", len(owned))
+	log.Info("Starting cache warm-up")
+
+	work := make(chan WarmKey)
+	wg := sync.WaitGroup{}
+	workers := viper.GetInt("cache.warm.concurrency")
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for wk := range work {
+				_, err := c.Fetch(ctx, wk.Group, wk.Key)
+				c.warm.lock.Lock()
+				// Not found still means the cache is warm for that key
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					c.warm.failed++
+					log.WithError(err).WithFields(logrus.Fields{
+						"group.name":     wk.Group,
+						"groupcache.key": wk.Key,
+					}).Info("Problem warming key")
+				} else {
+					c.warm.done++
+				}
+				c.warm.lock.Unlock()
+			}
+		}()
+	}
+	for _, wk := range owned {
+		work <- wk
+	}
+	close(work)
+	wg.Wait()
+
+	c.warm.lock.Lock()
+	c.warm.state = WarmStateDone
+	log = log.WithFields(logrus.Fields{
+		"warm.done":   c.warm.done,
+		"warm.failed": c.warm.failed,
+	})
+	c.warm.lock.Unlock()
+	log.Info("Cache warm-up done")
+}
@@ -0,0 +1,31 @@
+package handler_global
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"strings"
+)
+
+var (
+	ErrAdminDisabled = errors.New("admin api disabled")
+	ErrForbidden     = errors.New("forbidden")
+)
+
+func init() {
+	viper.SetDefault("admin.token", "") // Admin API (and /metrics) is disabled until this is set
+}
+
+//CheckAdminToken checks the request has the admin bearer token - shared by the admin API and /metrics
+func CheckAdminToken(c *gin.Context) error {
+	token := viper.GetString("admin.token")
+	if token == "" {
+		return ErrAdminDisabled
+	}
+	given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return ErrForbidden
+	}
+	return nil
+}
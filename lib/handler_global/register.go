@@ -1,10 +1,25 @@
 package handler_global
 
 import (
+	"context"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/metrics"
+	_ "github.com/fragforce/fragevents/lib/tracing" // Sets up the propagator + defaults
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"net/http"
+	"time"
 )
 
+func init() {
+	viper.SetDefault("ready.timeout", time.Second*5) // Max time for all readiness checks
+}
+
 func RegisterGlobalHandlers(r *gin.Engine) {
+	// Needs to be before any routes so it wraps all of them
+	r.Use(otelgin.Middleware(viper.GetString("tracing.service")), metrics.GinMiddleware)
+
 	// FIXME: Make not inline - Inline handler - just make sure we're alive
 	r.GET("/alive", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -13,6 +28,39 @@ func RegisterGlobalHandlers(r *gin.Engine) {
 			"error": nil,
 		})
 	})
+	r.GET("/ready", GetReady)
+	r.GET("/metrics", metricsAuth, metrics.Handler())
 
 	// Add more here that should be used for groupcache, web, etc
 }
+
+//metricsAuth keeps metrics (label values include routes, topics, and task types) to holders of the admin token
+func metricsAuth(c *gin.Context) {
+	if err := CheckAdminToken(c); err != nil {
+		df.Log.WithContext(c).WithError(err).Info("Metrics requested without the admin token")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"ok":    false,
+			"error": err.Error(),
+		})
+		return
+	}
+	c.Next()
+}
+
+//GetReady checks our dependencies - Keep /alive cheap and use this for deeper checks
+func GetReady(c *gin.Context) {
+	ctx, canc := context.WithTimeout(c, viper.GetDuration("ready.timeout"))
+	defer canc()
+
+	ready, components := df.RunReadyChecks(ctx)
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{
+		"ready":      ready,
+		"ok":         ready,
+		"components": components,
+	})
+}
@@ -1,11 +1,17 @@
 package handler_reg
 
 import (
+	"github.com/fragforce/fragevents/lib/handler_global"
 	"github.com/fragforce/fragevents/lib/handlers"
+	"github.com/fragforce/fragevents/lib/overlays"
 	"github.com/gin-gonic/gin"
+	"net/http"
 )
 
 func RegisterHandlers(r *gin.Engine) {
+	// Shared with the groupcache listener - alive/ready
+	handler_global.RegisterGlobalHandlers(r)
+
 	// Add more here that should only be used for web hosting
 
 	// Temp stuff
@@ -18,6 +24,33 @@ func RegisterHandlers(r *gin.Engine) {
 	r.GET("/v1/team/:teamid/", handlers.GetTeam)
 	r.GET("/v1/team/:teamid/participants/", handlers.GetTeamParticipants)
 	r.GET("/v1/participant/:participantid/", handlers.GetParticipant)
+	r.GET("/v1/participant/:participantid/donations/", handlers.GetParticipantDonations)
+	r.GET("/v1/team/:teamid/history/", handlers.GetTeamHistory)
+	r.GET("/v1/participant/:participantid/history/", handlers.GetParticipantHistory)
+	r.GET("/v1/team/:teamid/milestones/", handlers.GetTeamMilestones)
+	r.GET("/v1/participant/:participantid/milestones/", handlers.GetParticipantMilestones)
+	r.GET("/v1/event/:eventid/", handlers.GetEvent)
+	// Same as above but for a given donordrive platform - the above are for the default platform
+	platform := r.Group("/v1/platform/:platform")
+	platform.POST("/:rtype/register", handlers.RegisterType)
+	platform.GET("/team/:teamid/", handlers.GetTeam)
+	platform.GET("/team/:teamid/participants/", handlers.GetTeamParticipants)
+	platform.GET("/participant/:participantid/", handlers.GetParticipant)
+	platform.GET("/participant/:participantid/donations/", handlers.GetParticipantDonations)
+	platform.GET("/team/:teamid/history/", handlers.GetTeamHistory)
+	platform.GET("/participant/:participantid/history/", handlers.GetParticipantHistory)
+	platform.GET("/team/:teamid/milestones/", handlers.GetTeamMilestones)
+	platform.GET("/participant/:participantid/milestones/", handlers.GetParticipantMilestones)
+	platform.GET("/event/:eventid/", handlers.GetEvent)
+	// Overlays
+	r.GET("/overlay/:widget/:rtype/:id", handlers.GetOverlay)
+	r.StaticFS("/overlay-assets", http.FS(overlays.StaticFS()))
 	// Stats
 	r.GET("/v1/status", handlers.GetDetailedStatus)
+	// Admin
+	admin := r.Group("/v1/admin", handlers.AdminAuth)
+	admin.DELETE("/cache/:group/:key", handlers.AdminEvictCache)
+	admin.POST("/refresh/:rtype/:id", handlers.AdminRefresh)
+	admin.POST("/platform/:platform/refresh/:rtype/:id", handlers.AdminRefresh)
+	admin.GET("/monitors/:rtype", handlers.GetMonitors) // Has owners, labels, and notes
 }
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/gcache"
+	"github.com/fragforce/fragevents/lib/handler_global"
+	"github.com/fragforce/fragevents/lib/mondb"
+	"github.com/fragforce/fragevents/lib/tasks"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AdminEvictResponse struct {
+	*BaseResponse
+	Group string `json:"group"`
+	Key   string `json:"key"`
+}
+
+type AdminRefreshResponse struct {
+	*BaseResponse
+	Evicted        map[string]string   `json:"evicted"`                   // group => key
+	EvictedRelated map[string][]string `json:"evicted-related,omitempty"` // group => keys - e.g. a team's participants
+	TaskIDs        []string            `json:"task-ids"`
+}
+
+// refreshF builds the task(s) to enqueue to refresh the given id on the platform
+type refreshF func(ctx context.Context, platform string, id int) ([]*asynq.Task, error)
+
+// relatedF lists more cache entries (group => keys) to evict for the id - run after its own groups are evicted
+type relatedF func(ctx context.Context, platform string, id int) (map[string][]string, error)
+
+// refreshType is what an admin refresh of a given request type touches
+type refreshType struct {
+	groups  []string
+	tasks   refreshF
+	related relatedF // Optional
+}
+
+var (
+	ErrAdminDisabled = handler_global.ErrAdminDisabled
+	ErrForbidden     = handler_global.ErrForbidden
+	refreshTypes     = map[string]*refreshType{
+		df.RTypeTeam: {
+			groups: []string{gcache.GroupELTeam, gcache.GroupELParticipantForTeam},
+			tasks: func(ctx context.Context, platform string, id int) ([]*asynq.Task, error) {
+				t1, err := tasks.NewExtraLifeTeamRefreshTask(ctx, platform, id)
+				if err != nil {
+					return nil, err
+				}
+				t2, err := tasks.NewExtraLifeTeamRefreshParticipantTask(ctx, platform, id)
+				if err != nil {
+					return nil, err
+				}
+				return []*asynq.Task{t1, t2}, nil
+			},
+			// The participant updates would otherwise read totals cached from before the refresh
+			related: func(ctx context.Context, platform string, id int) (map[string][]string, error) {
+				participants, err := mondb.NewTeamMonitor(platform, id).GetTeamParticipants(ctx)
+				if err != nil {
+					return nil, err
+				}
+				keys := make([]string, 0, len(participants.Participants))
+				for _, p := range participants.Participants {
+					keys = append(keys, gcache.PlatformKey(platform, strconv.Itoa(p.ParticipantId)))
+				}
+				return map[string][]string{
+					gcache.GroupELParticipants: keys,
+					gcache.GroupELDonations:    keys,
+				}, nil
+			},
+		},
+		df.RTypeEvent: {
+			groups: []string{gcache.GroupELEvent},
+			tasks: func(ctx context.Context, platform string, id int) ([]*asynq.Task, error) {
+				t, err := tasks.NewExtraLifeEventRefreshTask(ctx, platform, id)
+				if err != nil {
+					return nil, err
+				}
+				return []*asynq.Task{t}, nil
+			},
+		},
+		df.RTypeParticipant: {
+			groups: []string{gcache.GroupELParticipants, gcache.GroupELDonations},
+			tasks: func(ctx context.Context, platform string, id int) ([]*asynq.Task, error) {
+				t, err := tasks.NewExtraLifeParticipantRefreshTask(ctx, platform, id)
+				if err != nil {
+					return nil, err
+				}
+				return []*asynq.Task{t}, nil
+			},
+		},
+	}
+)
+
+//AdminAuth is gin middleware that requires the admin bearer token
+func AdminAuth(c *gin.Context) {
+	log := df.Log.WithContext(c)
+
+	switch err := handler_global.CheckAdminToken(c); {
+	case errors.Is(err, ErrAdminDisabled):
+		log.WithError(err).Info("Admin API called but no admin token is set")
+		c.AbortWithStatusJSON(http.StatusForbidden, NewErrorResp(err, "Admin API disabled"))
+		return
+	case err != nil:
+		log.WithError(err).Info("Bad admin token")
+		c.AbortWithStatusJSON(http.StatusForbidden, NewErrorResp(err, "Forbidden"))
+		return
+	}
+
+	c.Next()
+}
+
+//AdminEvictCache removes a key from the named group across all peers
+func AdminEvictCache(c *gin.Context) {
+	groupName := c.Param("group")
+	key := c.Param("key")
+	log := df.Log.WithFields(logrus.Fields{
+		"group.name":     groupName,
+		"groupcache.key": key,
+	}).WithContext(c)
+
+	gca := gcache.GlobalCache()
+	if _, err := gca.GetGroupByName(groupName); err != nil {
+		log.WithError(err).Info("Couldn't get group cache")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Couldn't get group cache"))
+		return
+	}
+
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	if err := gca.Evict(ctx, groupName, key); err != nil {
+		log.WithError(err).Error("Problem removing key from group cache")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem removing key from group cache"))
+		return
+	}
+
+	log.Info("Evicted cache entry")
+	c.JSON(http.StatusOK, AdminEvictResponse{
+		BaseResponse: NewBaseResp(),
+		Group:        groupName,
+		Key:          key,
+	})
+}
+
+//AdminRefresh evicts all cached entries for the given type+id then queues an update to fetch+publish fresh data
+func AdminRefresh(c *gin.Context) {
+	rType := c.Param("rtype")
+	idStr := c.Param("id")
+	log := df.Log.WithFields(logrus.Fields{
+		"refresh.type":   rType,
+		"refresh.id.str": idStr,
+	}).WithContext(c)
+
+	rt, ok := refreshTypes[rType]
+	if !ok {
+		log.WithError(ErrNoSuchType).Info("Invalid refresh type requested")
+		c.JSON(http.StatusNotFound, NewErrorResp(ErrNoSuchType, "Invalid refresh type requested"))
+		return
+	}
+
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
+		return
+	}
+	log = log.WithField("donordrive.platform", platform)
+
+	id, err := strconv.ParseInt(idStr, 10, 32)
+	if err != nil || id <= 0 {
+		log.WithError(tasks.ErrInvalidID).Info("Invalid id for refresh")
+		c.JSON(http.StatusBadRequest, NewErrorResp(tasks.ErrInvalidID, "Invalid id for refresh"))
+		return
+	}
+	key := gcache.PlatformKey(platform, idStr)
+
+	// Build tasks before evicting so a bad id doesn't leave us half done
+	toQueue, err := rt.tasks(c.Request.Context(), platform, int(id))
+	if err != nil {
+		log.WithError(err).Error("Problem creating refresh tasks")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem creating refresh tasks"))
+		return
+	}
+
+	gca := gcache.GlobalCache()
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	evicted := make(map[string]string)
+	for _, groupName := range rt.groups {
+		log := log.WithField("group.name", groupName)
+		if err := gca.Evict(ctx, groupName, key); err != nil {
+			log.WithError(err).Error("Problem removing key from group cache")
+			c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem removing key from group cache"))
+			return
+		}
+		evicted[groupName] = key
+	}
+
+	var evictedRelated map[string][]string
+	if rt.related != nil {
+		related, err := rt.related(ctx, platform, int(id))
+		if err != nil {
+			log.WithError(err).Error("Problem listing related cache entries")
+			c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem listing related cache entries"))
+			return
+		}
+		evictedRelated = make(map[string][]string, len(related))
+		for groupName, keys := range related {
+			log := log.WithField("group.name", groupName)
+			for _, rKey := range keys {
+				if err := gca.Evict(ctx, groupName, rKey); err != nil {
+					log.WithError(err).WithField("groupcache.key", rKey).Error("Problem removing related key from group cache")
+					c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem removing related key from group cache"))
+					return
+				}
+				evictedRelated[groupName] = append(evictedRelated[groupName], rKey)
+			}
+		}
+	}
+
+	aClient := df.GetAsyncQClient()
+	taskIDs := make([]string, 0, len(toQueue))
+	for _, task := range toQueue {
+		tInfo, err := aClient.Enqueue(task)
+		if err != nil {
+			log.WithError(err).Error("Problem enqueuing refresh task")
+			c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem enqueuing refresh task"))
+			return
+		}
+		taskIDs = append(taskIDs, tInfo.ID)
+	}
+
+	log.WithField("task.ids", taskIDs).Info("Evicted and queued refresh")
+	c.JSON(http.StatusOK, AdminRefreshResponse{
+		BaseResponse:   NewBaseResp(),
+		Evicted:        evicted,
+		EvictedRelated: evictedRelated,
+		TaskIDs:        taskIDs,
+	})
+}
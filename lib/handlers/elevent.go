@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/gcache"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+type EventResponse struct {
+	*BaseResponse
+	Event *df.CachedEvent `json:"event"`
+}
+
+func GetEvent(c *gin.Context) {
+	eventID := c.Param("eventid")
+	log := df.Log.WithFields(logrus.Fields{
+		"event.id.str": eventID,
+	}).WithContext(c)
+
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
+		return
+	}
+	log = log.WithField("donordrive.platform", platform)
+
+	log.Trace("Setting up gca")
+	gca := gcache.GlobalCache()
+
+	log.Trace("Kicking off cache get/fill")
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	data, err := gca.Fetch(ctx, gcache.GroupELEvent, gcache.PlatformKey(platform, eventID))
+	if errors.Is(err, gcache.ErrNotFound) {
+		log.WithError(err).Info("Event not found")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Event not found"))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't get entry from event's group cache")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get entry from event's group cache"))
+		return
+	}
+
+	log.Trace("Unmarshalling")
+	// While we could get away without this, let's be sure the schema is right - security :)
+	event := df.CachedEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.WithError(err).Error("Couldn't unmarshal event")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't unmarshal event"))
+		return
+	}
+	log = log.WithField("teams.count", event.TeamCount)
+
+	log.Trace("All done")
+	c.JSON(http.StatusOK, EventResponse{
+		BaseResponse: NewBaseResp().WithStale(event.Stale, event.FetchedAt),
+		Event:        &event,
+	})
+}
@@ -3,10 +3,10 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/gcache"
 	"github.com/gin-gonic/gin"
-	"github.com/mailgun/groupcache/v2"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"time"
@@ -17,26 +17,38 @@ type ParticipantResponse struct {
 	Participant *df.CachedParticipant `json:"participant"`
 }
 
+type DonationsResponse struct {
+	*BaseResponse
+	Donations *df.CachedDonations `json:"donations"`
+}
+
 func GetParticipant(c *gin.Context) {
 	participantID := c.Param("participantid")
 	log := df.Log.WithFields(logrus.Fields{
 		"participant.id.str": participantID,
 	}).WithContext(c)
 
-	log.Trace("Setting up gca")
-	gca := gcache.GlobalCache()
-	participantCache, err := gca.GetGroupByName(gcache.GroupELParticipants)
+	platform, err := requestPlatform(c)
 	if err != nil {
-		log.WithError(err).Error("Couldn't get participant group cache")
-		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get participant group cache"))
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
 		return
 	}
+	log = log.WithField("donordrive.platform", platform)
+
+	log.Trace("Setting up gca")
+	gca := gcache.GlobalCache()
 
 	log.Trace("Kicking off cache get/fill")
-	var data []byte
 	ctx, canc := context.WithTimeout(c, time.Second*20)
 	defer canc()
-	if err := participantCache.Get(ctx, participantID, groupcache.AllocatingByteSliceSink(&data)); err != nil {
+	data, err := gca.Fetch(ctx, gcache.GroupELParticipants, gcache.PlatformKey(platform, participantID))
+	if errors.Is(err, gcache.ErrNotFound) {
+		log.WithError(err).Info("Participant not found")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Participant not found"))
+		return
+	}
+	if err != nil {
 		log.WithError(err).Error("Couldn't get entry from participant's group cache")
 		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get entry from participant's group cache"))
 		return
@@ -54,7 +66,56 @@ func GetParticipant(c *gin.Context) {
 
 	log.Trace("All done")
 	c.JSON(http.StatusOK, ParticipantResponse{
-		BaseResponse: NewBaseResp(),
+		BaseResponse: NewBaseResp().WithStale(participant.Stale, participant.FetchedAt),
 		Participant:  &participant,
 	})
 }
+
+func GetParticipantDonations(c *gin.Context) {
+	participantID := c.Param("participantid")
+	log := df.Log.WithFields(logrus.Fields{
+		"participant.id.str": participantID,
+	}).WithContext(c)
+
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
+		return
+	}
+	log = log.WithField("donordrive.platform", platform)
+
+	log.Trace("Setting up gca")
+	gca := gcache.GlobalCache()
+
+	log.Trace("Kicking off cache get/fill")
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	data, err := gca.Fetch(ctx, gcache.GroupELDonations, gcache.PlatformKey(platform, participantID))
+	if errors.Is(err, gcache.ErrNotFound) {
+		log.WithError(err).Info("Participant not found")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Participant not found"))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Couldn't get entry from donations group cache")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get entry from donations group cache"))
+		return
+	}
+
+	log.Trace("Unmarshalling")
+	// While we could get away without this, let's be sure the schema is right - security :)
+	donations := df.CachedDonations{}
+	if err := json.Unmarshal(data, &donations); err != nil {
+		log.WithError(err).Error("Couldn't unmarshal donations")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't unmarshal donations"))
+		return
+	}
+	log = log.WithField("donations.count", donations.Count)
+
+	log.Trace("All done")
+	c.JSON(http.StatusOK, DonationsResponse{
+		BaseResponse: NewBaseResp().WithStale(donations.Stale, donations.FetchedAt),
+		Donations:    &donations,
+	})
+}
@@ -3,10 +3,10 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/gcache"
 	"github.com/gin-gonic/gin"
-	"github.com/mailgun/groupcache/v2"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"time"
@@ -28,19 +28,27 @@ func GetTeam(c *gin.Context) {
 		"team.id.str": teamID,
 	}).WithContext(c)
 
-	log.Trace("Setting up gca")
-	gca := gcache.GlobalCache()
-	teamCache, err := gca.GetGroupByName(gcache.GroupELTeam)
+	platform, err := requestPlatform(c)
 	if err != nil {
-		log.WithError(err).Error("Couldn't get team group cache")
-		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get team group cache"))
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
 		return
 	}
+	log = log.WithField("donordrive.platform", platform)
+
+	log.Trace("Setting up gca")
+	gca := gcache.GlobalCache()
 
 	log.Trace("Kicking off cache get/fill")
-	var data []byte
-	ctx, _ := context.WithTimeout(c, time.Second*20)
-	if err := teamCache.Get(ctx, teamID, groupcache.AllocatingByteSliceSink(&data)); err != nil {
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	data, err := gca.Fetch(ctx, gcache.GroupELTeam, gcache.PlatformKey(platform, teamID))
+	if errors.Is(err, gcache.ErrNotFound) {
+		log.WithError(err).Info("Team not found")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Team not found"))
+		return
+	}
+	if err != nil {
 		log.WithError(err).Error("Couldn't get entry from team's group cache")
 		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get entry from team's group cache"))
 		return
@@ -58,7 +66,7 @@ func GetTeam(c *gin.Context) {
 
 	log.Trace("All done")
 	c.JSON(http.StatusOK, TeamResponse{
-		BaseResponse: NewBaseResp(),
+		BaseResponse: NewBaseResp().WithStale(team.Stale, team.FetchedAt),
 		Team:         &team,
 	})
 }
@@ -68,20 +76,27 @@ func GetTeamParticipants(c *gin.Context) {
 		"participants.id.str": teamID,
 	}).WithContext(c)
 
-	log.Trace("Setting up gca")
-	gca := gcache.GlobalCache()
-	teamParticipantsCache, err := gca.GetGroupByName(gcache.GroupELParticipantForTeam)
+	platform, err := requestPlatform(c)
 	if err != nil {
-		log.WithError(err).Error("Couldn't get participants participants group cache")
-		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get participants participants group cache"))
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
 		return
 	}
+	log = log.WithField("donordrive.platform", platform)
+
+	log.Trace("Setting up gca")
+	gca := gcache.GlobalCache()
 
 	log.Trace("Kicking off cache get/fill")
-	var data []byte
 	ctx, canc := context.WithTimeout(c, time.Second*20)
 	defer canc()
-	if err := teamParticipantsCache.Get(ctx, teamID, groupcache.AllocatingByteSliceSink(&data)); err != nil {
+	data, err := gca.Fetch(ctx, gcache.GroupELParticipantForTeam, gcache.PlatformKey(platform, teamID))
+	if errors.Is(err, gcache.ErrNotFound) {
+		log.WithError(err).Info("Team not found")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Team not found"))
+		return
+	}
+	if err != nil {
 		log.WithError(err).Error("Couldn't get entry from participants's participants group cache")
 		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Couldn't get entry from participants's participants group cache"))
 		return
@@ -99,7 +114,7 @@ func GetTeamParticipants(c *gin.Context) {
 
 	log.Trace("All done")
 	c.JSON(http.StatusOK, ParticipantsResponse{
-		BaseResponse: NewBaseResp(),
+		BaseResponse: NewBaseResp().WithStale(participants.Stale, participants.FetchedAt),
 		Participants: &participants,
 	})
 }
@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"github.com/fragforce/fragevents/lib/ddrive"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/gcache"
 	"github.com/gin-gonic/gin"
 	"github.com/mailgun/groupcache/v2"
 	"net/http"
+	"time"
 )
 
 const (
@@ -13,15 +15,20 @@ const (
 )
 
 type BaseResponse struct {
-	Ok      bool   `json:"ok"`
-	Message string `json:"message"`
-	Err     error  `json:"error,omitempty"`
+	Ok         bool    `json:"ok"`
+	Message    string  `json:"message"`
+	Err        error   `json:"error,omitempty"`
+	Stale      bool    `json:"stale,omitempty"`       // Data is the last good copy since the origin is failing
+	AgeSeconds float64 `json:"age-seconds,omitempty"` // Only set for stale data
 }
 
 type DetailedStatusResponse struct {
 	*BaseResponse
-	Caches          map[string]groupcache.Stats `json:"cache-stats"`
-	CachePeersCount int                         `json:"cache-peers-count"`
+	Caches            map[string]groupcache.Stats      `json:"cache-stats"`
+	CachePeersCount   int                              `json:"cache-peers-count"`
+	CachePeers        []string                         `json:"cache-peers"`
+	CachePeerEvents   []gcache.PeerEvent               `json:"cache-peer-events"`
+	DonorDriveBudgets map[string]*ddrive.LimiterStatus `json:"donordrive-budgets,omitempty"` // Platform id => budget
 }
 
 //NewErrorResp creates a new base response - should only be used for bad calls
@@ -41,6 +48,15 @@ func NewBaseResp() *BaseResponse {
 	}
 }
 
+//WithStale marks the response as stale, if it is, along with the data's age
+func (r *BaseResponse) WithStale(stale bool, fetchedAt time.Time) *BaseResponse {
+	if stale {
+		r.Stale = true
+		r.AgeSeconds = time.Since(fetchedAt).Seconds()
+	}
+	return r
+}
+
 func GetDetailedStatus(c *gin.Context) {
 	log := df.Log.WithContext(c)
 	gca := gcache.GlobalCache()
@@ -64,9 +80,32 @@ func GetDetailedStatus(c *gin.Context) {
 		cStatus[group.Name()] = group.Stats
 	}
 
+	// Rate limit budgets - not fatal if we can't get them
+	budgets := make(map[string]*ddrive.LimiterStatus)
+	for _, platform := range ddrive.Platforms() {
+		log := log.WithField("donordrive.platform", platform)
+		client, err := ddrive.ForPlatform(platform)
+		if err != nil {
+			log.WithError(err).Warn("Couldn't get donordrive client")
+			continue
+		}
+		if client.Limiter == nil {
+			continue
+		}
+		budget, err := client.Limiter.Status(c)
+		if err != nil {
+			log.WithError(err).Warn("Couldn't get donordrive rate limit status")
+			continue
+		}
+		budgets[platform] = budget
+	}
+
 	c.JSON(http.StatusOK, DetailedStatusResponse{
-		BaseResponse:    NewBaseResp(),
-		Caches:          cStatus,
-		CachePeersCount: len(peers),
+		BaseResponse:      NewBaseResp(),
+		Caches:            cStatus,
+		CachePeersCount:   len(peers),
+		CachePeers:        peers,
+		CachePeerEvents:   gca.PeerEvents(),
+		DonorDriveBudgets: budgets,
 	})
 }
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/mondb"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidRange = errors.New("invalid history range")
+)
+
+type HistoryResponse struct {
+	*BaseResponse
+	History *mondb.History `json:"history"`
+}
+
+func GetTeamHistory(c *gin.Context) {
+	getHistory(c, df.MonitorNameTeam, c.Param("teamid"))
+}
+
+func GetParticipantHistory(c *gin.Context) {
+	getHistory(c, df.MonitorNameParticipant, c.Param("participantid"))
+}
+
+//parseHistoryTime parses a from/to param - RFC3339 or a duration before now (e.g. 6h) - def if it's empty
+func parseHistoryTime(v string, now time.Time, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, ErrInvalidRange
+}
+
+func getHistory(c *gin.Context, monName string, idStr string) {
+	log := df.Log.WithFields(logrus.Fields{
+		"monitor.name": monName,
+		"id.str":       idStr,
+	}).WithContext(c)
+
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
+		return
+	}
+	log = log.WithField("donordrive.platform", platform)
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		log.WithError(ErrInvalidID).Info("Invalid id")
+		c.JSON(http.StatusBadRequest, NewErrorResp(ErrInvalidID, "Invalid id"))
+		return
+	}
+
+	now := time.Now()
+	from, err := parseHistoryTime(c.Query("from"), now, now.Add(-time.Hour*24))
+	if err != nil {
+		log.WithError(err).Info("Invalid from")
+		c.JSON(http.StatusBadRequest, NewErrorResp(err, "Invalid from - use RFC3339 or a duration ago like 6h"))
+		return
+	}
+	to, err := parseHistoryTime(c.Query("to"), now, now)
+	if err != nil {
+		log.WithError(err).Info("Invalid to")
+		c.JSON(http.StatusBadRequest, NewErrorResp(err, "Invalid to - use RFC3339 or a duration ago like 6h"))
+		return
+	}
+	if !to.After(from) {
+		log.WithError(ErrInvalidRange).Info("To isn't after from")
+		c.JSON(http.StatusBadRequest, NewErrorResp(ErrInvalidRange, "To isn't after from"))
+		return
+	}
+
+	res, err := mondb.ParseResolution(c.DefaultQuery("resolution", "5m"))
+	if err != nil {
+		log.WithError(err).Info("Invalid resolution")
+		c.JSON(http.StatusBadRequest, NewErrorResp(err, "Invalid resolution - use raw or a duration of at least 1m"))
+		return
+	}
+	log = log.WithFields(logrus.Fields{
+		"history.from":       from,
+		"history.to":         to,
+		"history.resolution": res,
+	})
+
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	history, err := mondb.GetHistory(ctx, monName, platform, id, from, to, res)
+	if errors.Is(err, mondb.ErrTooManyPoints) {
+		log.WithError(err).Info("Too many points")
+		c.JSON(http.StatusBadRequest, NewErrorResp(err, "Too many points - use a coarser resolution or a shorter range"))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Problem getting history")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem getting history"))
+		return
+	}
+
+	log.WithField("history.points", len(history.Points)).Trace("All done")
+	c.JSON(http.StatusOK, HistoryResponse{
+		BaseResponse: NewBaseResp(),
+		History:      history,
+	})
+}
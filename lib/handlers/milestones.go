@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/mondb"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type MilestonesResponse struct {
+	*BaseResponse
+	Milestones mondb.Milestones       `json:"milestones"` // What's being watched for
+	Reached    []*df.MilestoneReached `json:"reached"`
+	Monitored  bool                   `json:"monitored"` // Milestones are only checked while monitored
+}
+
+func GetTeamMilestones(c *gin.Context) {
+	getMilestones(c, df.RTypeTeam, c.Param("teamid"))
+}
+
+func GetParticipantMilestones(c *gin.Context) {
+	getMilestones(c, df.RTypeParticipant, c.Param("participantid"))
+}
+
+func getMilestones(c *gin.Context, rType string, idStr string) {
+	log := df.Log.WithFields(logrus.Fields{
+		"rtype":  rType,
+		"id.str": idStr,
+	}).WithContext(c)
+
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
+		return
+	}
+	log = log.WithField("donordrive.platform", platform)
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		log.WithError(ErrInvalidID).Info("Invalid id")
+		c.JSON(http.StatusBadRequest, NewErrorResp(ErrInvalidID, "Invalid id"))
+		return
+	}
+
+	var m mondb.Monitor
+	var load func(ctx context.Context) (bool, error)
+	switch rType {
+	case df.RTypeTeam:
+		tm := mondb.NewTeamMonitor(platform, id)
+		m, load = tm, tm.Load
+	default:
+		pm := mondb.NewParticipantMonitor(platform, id)
+		m, load = pm, pm.Load
+	}
+
+	ctx, canc := context.WithTimeout(c, time.Second*20)
+	defer canc()
+	monitored, err := load(ctx)
+	if err != nil {
+		log.WithError(err).Error("Problem loading monitor")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem loading monitor"))
+		return
+	}
+
+	reached, err := mondb.GetReachedMilestones(ctx, m)
+	if err != nil {
+		log.WithError(err).Error("Problem getting reached milestones")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem getting reached milestones"))
+		return
+	}
+
+	log.WithField("milestones.reached", len(reached)).Trace("All done")
+	c.JSON(http.StatusOK, MilestonesResponse{
+		BaseResponse: NewBaseResp(),
+		Milestones:   m.GetBase().GetMilestones(),
+		Reached:      reached,
+		Monitored:    monitored,
+	})
+}
@@ -9,32 +9,96 @@ import (
 	"github.com/spf13/viper"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type RegisterTypeResponse struct {
 	*BaseResponse
 }
 
+// RegisterMetaRequest is the optional metadata and monitoring window that can go with any register request
+type RegisterMetaRequest struct {
+	Owner    string   `json:"owner"`
+	Client   string   `json:"client"` // Defaults to the User-Agent
+	Labels   []string `json:"labels"`
+	Notes    string   `json:"notes"`
+	Start    string   `json:"start"`    // RFC3339 or a local time in Timezone - empty is now
+	End      string   `json:"end"`      // RFC3339 or a local time in Timezone - empty is the normal active period
+	Timezone string   `json:"timezone"` // IANA name, e.g. America/New_York - empty is UTC
+	// Teams and participants only - both missing or empty uses the defaults
+	MilestonePercents []float64 `json:"milestone-percents"`
+	MilestoneAmounts  []float64 `json:"milestone-amounts"`
+}
+
 type RTypeTeamRequest struct {
+	RegisterMetaRequest
 	TeamID int `json:"team-id"`
 }
 
 type RTTypeParticipantRequest struct {
+	RegisterMetaRequest
 	ParticipantID int `json:"participant-id"`
 }
 
+type RTypeEventRequest struct {
+	RegisterMetaRequest
+	EventID int `json:"event-id"`
+}
+
+type MonitorsResponse struct {
+	*BaseResponse
+	Monitors []*MonitorListItem `json:"monitors"`
+	Count    int                `json:"count"`
+}
+
+type MonitorListItem struct {
+	Monitor mondb.Monitor `json:"monitor"`
+	State   string        `json:"state"` // Where now is in the monitor's window
+}
+
 type TypeHandlerF func(rType string, c *gin.Context, log *logrus.Entry) (int, error)
 
 var (
 	ErrNoSuchType    = errors.New("no such register type")
+	ErrInvalidID     = errors.New("invalid id")
 	typeHandlers     map[string]TypeHandlerF
 	typeHandlersLock *sync.Mutex
+	monitorNames     = map[string]string{ // Register type => monitor name
+		df.RTypeTeam:        df.MonitorNameTeam,
+		df.RTypeParticipant: df.MonitorNameParticipant,
+		df.RTypeEvent:       df.MonitorNameEvent,
+	}
 )
 
 func init() {
 	initTHand()
 	RegisterTypeHandler(df.RTypeParticipant, RTTypeParticipantHandler)
 	RegisterTypeHandler(df.RTypeTeam, RTypeTeamHandler)
+	RegisterTypeHandler(df.RTypeEvent, RTypeEventHandler)
+}
+
+//apply validates the request's metadata and window then sets them on the monitor
+func (r *RegisterMetaRequest) apply(c *gin.Context, m *mondb.BaseMonitor) error {
+	client := r.Client
+	if client == "" {
+		client = c.Request.UserAgent()
+	}
+	meta, err := mondb.NewMonitorMeta(r.Owner, client, r.Labels, r.Notes)
+	if err != nil {
+		return err
+	}
+	window, err := mondb.NewMonitorWindow(r.Start, r.End, r.Timezone)
+	if err != nil {
+		return err
+	}
+	milestones, err := mondb.NewMilestones(r.MilestonePercents, r.MilestoneAmounts)
+	if err != nil {
+		return err
+	}
+	m.MonitorMeta = meta
+	m.MonitorWindow = window
+	m.Milestones = milestones
+	return nil
 }
 
 func initTHand() { // Can be called many times
@@ -58,7 +122,17 @@ func RTypeTeamHandler(rType string, c *gin.Context, log *logrus.Entry) (statusCo
 
 	// FIXME: Add in TeamID checks
 
-	tm := mondb.NewTeamMonitor(tr.TeamID)
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		return http.StatusNotFound, err
+	}
+
+	tm := mondb.NewTeamMonitor(platform, tr.TeamID)
+	if err := tr.apply(c, tm.BaseMonitor); err != nil {
+		log.WithError(err).Info("Invalid monitor metadata or window")
+		return http.StatusBadRequest, err
+	}
 	if err := tm.SetUpdateMonitoring(c); err != nil {
 		log.WithError(err).Info("Problem enabling monitoring")
 		return http.StatusInternalServerError, err
@@ -76,7 +150,17 @@ func RTTypeParticipantHandler(rType string, c *gin.Context, log *logrus.Entry) (
 
 	// FIXME: Add in ParticipantID checks
 
-	tm := mondb.NewParticipantMonitor(tr.ParticipantID)
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		return http.StatusNotFound, err
+	}
+
+	tm := mondb.NewParticipantMonitor(platform, tr.ParticipantID)
+	if err := tr.apply(c, tm.BaseMonitor); err != nil {
+		log.WithError(err).Info("Invalid monitor metadata or window")
+		return http.StatusBadRequest, err
+	}
 	if err := tm.SetUpdateMonitoring(c, viper.GetDuration("participant.active")); err != nil {
 		log.WithError(err).Info("Problem enabling monitoring")
 		return http.StatusInternalServerError, err
@@ -85,6 +169,36 @@ func RTTypeParticipantHandler(rType string, c *gin.Context, log *logrus.Entry) (
 	return http.StatusOK, nil
 }
 
+func RTypeEventHandler(rType string, c *gin.Context, log *logrus.Entry) (statusCode int, err error) {
+	er := RTypeEventRequest{}
+	if err := c.BindJSON(&er); err != nil {
+		log.WithError(err).Info("Problem binding JSON in request")
+		return http.StatusBadRequest, err
+	}
+	if er.EventID <= 0 {
+		log.WithError(ErrInvalidID).Info("Invalid event id")
+		return http.StatusBadRequest, ErrInvalidID
+	}
+
+	platform, err := requestPlatform(c)
+	if err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		return http.StatusNotFound, err
+	}
+
+	em := mondb.NewEventMonitor(platform, er.EventID)
+	if err := er.apply(c, em.BaseMonitor); err != nil {
+		log.WithError(err).Info("Invalid monitor metadata or window")
+		return http.StatusBadRequest, err
+	}
+	if err := em.SetUpdateMonitoring(c); err != nil {
+		log.WithError(err).Info("Problem enabling monitoring")
+		return http.StatusInternalServerError, err
+	}
+
+	return http.StatusOK, nil
+}
+
 func RegisterTypeHandler(name string, f TypeHandlerF) {
 	initTHand() // Just to be safe
 
@@ -97,9 +211,16 @@ func RegisterTypeHandler(name string, f TypeHandlerF) {
 func RegisterType(c *gin.Context) {
 	rType := c.Param("rtype")
 	log := df.Log.WithFields(logrus.Fields{
-		"register.type": rType,
+		"register.type":       rType,
+		"donordrive.platform": c.Param("platform"),
 	}).WithContext(c)
 
+	if _, err := requestPlatform(c); err != nil {
+		log.WithError(err).Info("Unknown donordrive platform")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform"))
+		return
+	}
+
 	handlerF, ok := typeHandlers[rType]
 	if !ok {
 		log.WithError(ErrNoSuchType).Info("Invalid register type requested")
@@ -110,8 +231,8 @@ func RegisterType(c *gin.Context) {
 	scode, err := handlerF(rType, c, log)
 	log = log.WithField("ret.status.code", scode)
 	if err != nil {
-		log.WithError(ErrNoSuchType).Info("Invalid register type requested")
-		c.JSON(scode, NewErrorResp(ErrNoSuchType, "Invalid register type requested"))
+		log.WithError(err).Info("Problem registering monitor")
+		c.JSON(scode, NewErrorResp(err, "Problem registering monitor"))
 		return
 	}
 
@@ -126,3 +247,48 @@ func RegisterType(c *gin.Context) {
 		BaseResponse: NewBaseResp(),
 	})
 }
+
+//GetMonitors lists the monitors of the given type - filterable by label (repeatable, must have all), owner, client, platform, and state
+func GetMonitors(c *gin.Context) {
+	rType := c.Param("rtype")
+	log := df.Log.WithFields(logrus.Fields{
+		"register.type": rType,
+	}).WithContext(c)
+
+	monName, ok := monitorNames[rType]
+	if !ok {
+		log.WithError(ErrNoSuchType).Info("Invalid monitor type requested")
+		c.JSON(http.StatusNotFound, NewErrorResp(ErrNoSuchType, "Invalid monitor type requested"))
+		return
+	}
+
+	filter := mondb.MonitorFilter{
+		Labels:   c.QueryArray("label"),
+		Owner:    c.Query("owner"),
+		Client:   c.Query("client"),
+		Platform: c.Query("platform"),
+		State:    c.Query("state"),
+	}
+	monitors, err := mondb.GetAllMonitors(c, monName, &filter)
+	if err != nil {
+		log.WithError(err).Error("Problem listing monitors")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem listing monitors"))
+		return
+	}
+
+	now := time.Now()
+	items := make([]*MonitorListItem, 0, len(monitors))
+	for _, m := range monitors {
+		items = append(items, &MonitorListItem{
+			Monitor: m,
+			State:   m.GetBase().WindowState(now),
+		})
+	}
+
+	log.WithField("monitors.count", len(items)).Trace("All done")
+	c.JSON(http.StatusOK, MonitorsResponse{
+		BaseResponse: NewBaseResp(),
+		Monitors:     items,
+		Count:        len(items),
+	})
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/fragforce/fragevents/lib/ddrive"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/overlays"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+//GetOverlay renders an embeddable overlay page for the given widget, type, and id
+func GetOverlay(c *gin.Context) {
+	widgetName := c.Param("widget")
+	rType := c.Param("rtype")
+	id := c.Param("id")
+	log := df.Log.WithFields(logrus.Fields{
+		"overlay.widget": widgetName,
+		"overlay.rtype":  rType,
+		"overlay.id.str": id,
+	}).WithContext(c)
+
+	widget, err := overlays.GetWidget(widgetName)
+	if err != nil {
+		log.WithError(err).Info("Invalid overlay widget requested")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Invalid overlay widget requested"))
+		return
+	}
+
+	if _, err := strconv.ParseInt(id, 10, 32); err != nil {
+		log.WithError(err).Info("Invalid id for overlay")
+		c.JSON(http.StatusBadRequest, NewErrorResp(err, "Invalid id for overlay"))
+		return
+	}
+
+	// Optional - validate it so a typo doesn't just give a page that never loads
+	platform := c.Query("platform")
+	if platform != "" {
+		if _, err := ddrive.ResolvePlatform(platform); err != nil {
+			log.WithError(err).Info("Unknown donordrive platform for overlay")
+			c.JSON(http.StatusNotFound, NewErrorResp(err, "Unknown donordrive platform for overlay"))
+			return
+		}
+	}
+
+	endpoint, err := widget.Endpoint(platform, rType, id)
+	if err != nil {
+		log.WithError(err).Info("Overlay widget doesn't support the requested type")
+		c.JSON(http.StatusNotFound, NewErrorResp(err, "Overlay widget doesn't support the requested type"))
+		return
+	}
+
+	theme := overlays.ParseTheme(c.Request.URL.Query(), widget)
+
+	// Render to a buffer first so a template error doesn't leave a half written page
+	bf := new(bytes.Buffer)
+	if err := overlays.Render(bf, &overlays.PageData{
+		Widget:   widget,
+		RType:    rType,
+		ID:       id,
+		Endpoint: endpoint,
+		Theme:    theme,
+	}); err != nil {
+		log.WithError(err).Error("Problem rendering overlay")
+		c.JSON(http.StatusInternalServerError, NewErrorResp(err, "Problem rendering overlay"))
+		return
+	}
+
+	log.Trace("All done")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", bf.Bytes())
+}
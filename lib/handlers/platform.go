@@ -0,0 +1,11 @@
+package handlers
+
+import (
+	"github.com/fragforce/fragevents/lib/ddrive"
+	"github.com/gin-gonic/gin"
+)
+
+//requestPlatform gets the donordrive platform from the route - routes without one are for the default platform
+func requestPlatform(c *gin.Context) (string, error) {
+	return ddrive.ResolvePlatform(c.Param("platform"))
+}
@@ -13,9 +13,49 @@ import (
 	"time"
 )
 
+var (
+	ErrNoKafkaAddrs         = errors.New("no kafka urls configured")
+	ErrUnknownKafkaCompress = errors.New("unknown kafka compression")
+	kafkaCompressions       = map[string]kafka.Compression{
+		"none":   0,
+		"gzip":   kafka.Gzip,
+		"snappy": kafka.Snappy,
+		"lz4":    kafka.Lz4,
+		"zstd":   kafka.Zstd,
+	}
+)
+
 func init() {
 	viper.SetDefault("kafka.conn.timeout", 10*time.Second)
 	viper.SetDefault("kafka.conn.idle", 300)
+	viper.SetDefault("kafka.compression", "gzip") // none, gzip, snappy, lz4 or zstd - applies to each batch
+	df.RegisterReadyCheck("kafka", false, kafkaReadyCheck)
+}
+
+//kafkaCompression is the batch compression codec for our writers
+func kafkaCompression() (kafka.Compression, error) {
+	algo := viper.GetString("kafka.compression")
+	c, ok := kafkaCompressions[algo]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownKafkaCompress, algo)
+	}
+	return c, nil
+}
+
+//kafkaAddrs turns the configured kafka urls into broker host:port addrs
+func kafkaAddrs() ([]string, error) {
+	kURLs := viper.GetStringSlice("kafka.urls")
+	addrs := make([]string, 0)
+	for _, kURL := range kURLs {
+		u, err := url.ParseRequestURI(kURL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host != "" {
+			addrs = append(addrs, u.Host)
+		}
+	}
+	return addrs, nil
 }
 
 func newTLSConfig() (*tls.Config, error) {
@@ -104,22 +144,20 @@ func NewKafkaWriter(ctx context.Context, topic string) (writer *kafka.Writer, er
 		return nil, err
 	}
 
-	kURLs := viper.GetStringSlice("kafka.urls")
-	addrs := make([]string, 0)
-	for _, kURL := range kURLs {
-		log := log.WithField("url.raw", kURLs)
-		u, err := url.ParseRequestURI(kURL)
-		if err != nil {
-			log.WithError(err).Error("Problem making url into url")
-			return nil, err
-		}
-		if u.Host != "" {
-			addrs = append(addrs, u.Host)
-		}
+	addrs, err := kafkaAddrs()
+	if err != nil {
+		log.WithError(err).Error("Problem getting kafka addrs")
+		return nil, err
 	}
 	log = log.WithField("kafka.addrs", addrs)
 	log.Trace("Set kafka addrs")
 
+	compression, err := kafkaCompression()
+	if err != nil {
+		log.WithError(err).Error("Problem getting kafka compression")
+		return nil, err
+	}
+
 	writer = &kafka.Writer{
 		Addr:                   kafka.TCP(addrs...),
 		Topic:                  topic,
@@ -132,6 +170,7 @@ func NewKafkaWriter(ctx context.Context, topic string) (writer *kafka.Writer, er
 		Logger:                 log,
 		ErrorLogger:            log,
 		Transport:              transport,
+		Compression:            compression,
 		AllowAutoTopicCreation: false, // We can't do this in Heroku
 	}
 
@@ -139,3 +178,31 @@ func NewKafkaWriter(ctx context.Context, topic string) (writer *kafka.Writer, er
 
 	return
 }
+
+//kafkaReadyCheck makes sure we can talk to the kafka brokers the writers use
+func kafkaReadyCheck(ctx context.Context) (string, error) {
+	addrs, err := kafkaAddrs()
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", ErrNoKafkaAddrs
+	}
+
+	transport, err := newKafkaTransport(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer transport.CloseIdleConnections()
+
+	client := kafka.Client{
+		Addr:      kafka.TCP(addrs...),
+		Timeout:   viper.GetDuration("kafka.conn.timeout"),
+		Transport: transport,
+	}
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d brokers, %d topics", len(meta.Brokers), len(meta.Topics)), nil
+}
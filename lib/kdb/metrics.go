@@ -0,0 +1,83 @@
+package kdb
+
+import (
+	"github.com/fragforce/fragevents/lib/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/segmentio/kafka-go"
+	"sync"
+)
+
+// writerTotals are the running totals for one topic - kafka.Writer.Stats resets its counters on every call
+type writerTotals struct {
+	writes   int64
+	messages int64
+	bytes    int64
+	errors   int64
+}
+
+// writerCollector exports the stats of all the writers in W
+type writerCollector struct {
+	lock     *sync.Mutex
+	totals   map[string]*writerTotals
+	writes   *prometheus.Desc
+	messages *prometheus.Desc
+	bytes    *prometheus.Desc
+	errors   *prometheus.Desc
+	writeAvg *prometheus.Desc
+}
+
+func init() {
+	prometheus.MustRegister(newWriterCollector())
+}
+
+func newWriterCollector() *writerCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "kafka_writer", name), help, []string{"topic"}, nil)
+	}
+	return &writerCollector{
+		lock:     &sync.Mutex{},
+		totals:   make(map[string]*writerTotals),
+		writes:   desc("writes_total", "Batches written"),
+		messages: desc("messages_total", "Messages written"),
+		bytes:    desc("bytes_total", "Message bytes written"),
+		errors:   desc("errors_total", "Write errors"),
+		writeAvg: desc("write_seconds_avg", "Average batch write time since the last scrape"),
+	}
+}
+
+func (wc *writerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wc.writes
+	ch <- wc.messages
+	ch <- wc.bytes
+	ch <- wc.errors
+	ch <- wc.writeAvg
+}
+
+func (wc *writerCollector) Collect(ch chan<- prometheus.Metric) {
+	W.lock.Lock()
+	stats := make(map[string]kafka.WriterStats, len(W.writers))
+	for topic, wr := range W.writers {
+		stats[topic] = wr.Stats()
+	}
+	W.lock.Unlock()
+
+	wc.lock.Lock()
+	defer wc.lock.Unlock()
+	for topic, s := range stats {
+		t, ok := wc.totals[topic]
+		if !ok {
+			t = &writerTotals{}
+			wc.totals[topic] = t
+		}
+		t.writes += s.Writes
+		t.messages += s.Messages
+		t.bytes += s.Bytes
+		t.errors += s.Errors
+
+		ch <- prometheus.MustNewConstMetric(wc.writes, prometheus.CounterValue, float64(t.writes), topic)
+		ch <- prometheus.MustNewConstMetric(wc.messages, prometheus.CounterValue, float64(t.messages), topic)
+		ch <- prometheus.MustNewConstMetric(wc.bytes, prometheus.CounterValue, float64(t.bytes), topic)
+		ch <- prometheus.MustNewConstMetric(wc.errors, prometheus.CounterValue, float64(t.errors), topic)
+		ch <- prometheus.MustNewConstMetric(wc.writeAvg, prometheus.GaugeValue, s.WriteTime.Avg.Seconds(), topic)
+	}
+}
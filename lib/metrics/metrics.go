@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strconv"
+	"time"
+)
+
+const (
+	Namespace = "fragevents"
+)
+
+var (
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by gin route",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "tasks",
+		Name:      "duration_seconds",
+		Help:      "Asynq task run time by task type",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "result"})
+	donorDriveDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "donordrive",
+		Name:      "request_duration_seconds",
+		Help:      "DonorDrive api latency by endpoint - code is 'error' if there was no response",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(httpDuration, taskDuration, donorDriveDuration)
+}
+
+//Handler serves /metrics
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+//GinMiddleware records the latency of each request by route pattern - not raw path, to keep the label count sane
+func GinMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+}
+
+//AsynqMiddleware records the run time and result of each task - for asynq.ServeMux.Use
+func AsynqMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		start := time.Now()
+		err := next.ProcessTask(ctx, t)
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		taskDuration.WithLabelValues(t.Type(), result).Observe(time.Since(start).Seconds())
+		return err
+	})
+}
+
+//ObserveDonorDrive records a single DonorDrive api call - code is 0 if there was no response
+func ObserveDonorDrive(endpoint string, code int, took time.Duration) {
+	codeStr := "error"
+	if code > 0 {
+		codeStr = strconv.Itoa(code)
+	}
+	donorDriveDuration.WithLabelValues(endpoint, codeStr).Observe(took.Seconds())
+}
@@ -0,0 +1,229 @@
+package mondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/gcache"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TierHot    = "hot"    // Live or had a donation recently
+	TierNormal = "normal" // Everything else
+	TierIdle   = "idle"   // No donations for a long while and not live
+)
+
+var (
+	// The cache groups each monitor type's update reads from - the first is the one that sets the floor
+	cadenceGroups = map[string][]string{
+		df.MonitorNameTeam:        {gcache.GroupELTeam},
+		df.MonitorNameParticipant: {gcache.GroupELParticipants, gcache.GroupELDonations},
+		df.MonitorNameEvent:       {gcache.GroupELEvent},
+	}
+)
+
+// cachedMonitor is a monitor whose update reads from the cache groups in cadenceGroups
+type cachedMonitor interface {
+	Monitor
+	CacheKey() string
+}
+
+// Activity is what we go on to pick a monitor's tier
+type Activity struct {
+	Live  bool
+	Total float64 // Donation total - any change counts as a new donation
+}
+
+func init() {
+	viper.SetDefault("cadence.tick", time.Second*15)         // How often the fan-out tasks look for due monitors
+	viper.SetDefault("cadence.lease", time.Minute*2)         // Due monitors aren't due again for this long - the update sets the real next time
+	viper.SetDefault("cadence.min", time.Second*15)          // No monitor is polled more often than this
+	viper.SetDefault("cadence.max", time.Hour)               // ...or less often than this
+	viper.SetDefault("cadence.hot.window", time.Minute*15)   // A donation in this long makes a monitor hot
+	viper.SetDefault("cadence.idle.after", time.Hour*2)      // No donation in this long makes a monitor idle
+	viper.SetDefault("cadence.activity.ttl", time.Hour*24*7) // How long to remember activity for monitors that stop being polled
+	// Never more often than the cached data they read expires - see cacheFloor
+	viper.SetDefault("cadence.team.hot", time.Minute*2)
+	viper.SetDefault("cadence.team.normal", time.Minute*30)
+	viper.SetDefault("cadence.team.idle", time.Hour)
+	viper.SetDefault("cadence.participant.hot", time.Minute*2)
+	viper.SetDefault("cadence.participant.normal", time.Minute*30)
+	viper.SetDefault("cadence.participant.idle", time.Hour)
+	viper.SetDefault("cadence.event.hot", time.Minute*2)
+	viper.SetDefault("cadence.event.normal", time.Minute*30)
+	viper.SetDefault("cadence.event.idle", time.Hour)
+}
+
+//dueKey is the sorted set of monitor key => next due time (unix ms) for the monitor type
+func dueKey(monName string) string {
+	return fmt.Sprintf("cadence-due-%s", monName)
+}
+
+//activityKey is the hash of the last seen donation total and when it last changed
+func activityKey(monKey string) string {
+	return fmt.Sprintf("cadence-activity-%s", monKey)
+}
+
+//TierCadence is how often to poll the monitor type in the given tier - within cadence.min and cadence.max
+func TierCadence(monName string, tier string) time.Duration {
+	d := viper.GetDuration(fmt.Sprintf("cadence.%s.%s", strings.ToLower(monName), tier))
+	if min := viper.GetDuration("cadence.min"); d < min {
+		d = min
+	}
+	if max := viper.GetDuration("cadence.max"); max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+//cacheFloor is how long the monitor type's cached data lasts - polling more often would just re-read the same snapshot
+// Hot monitors use the live ttl either way - ones that aren't live have their entry evicted instead, see evictHot
+func cacheFloor(monName string, tier string) time.Duration {
+	groups, ok := cadenceGroups[monName]
+	if !ok {
+		return 0
+	}
+	if tier == TierHot {
+		return gcache.GroupTTL(groups[0], gcache.TTLLive)
+	}
+	return gcache.GroupTTL(groups[0])
+}
+
+//pickTier works out the tier from how long ago the donation total last changed
+func pickTier(live bool, sinceChange time.Duration) string {
+	switch {
+	case live || sinceChange < viper.GetDuration("cadence.hot.window"):
+		return TierHot
+	case sinceChange >= viper.GetDuration("cadence.idle.after"):
+		return TierIdle
+	}
+	return TierNormal
+}
+
+//pickCadence works out the tier and how long until the next update - within the tier's cadence and the cache floor
+func pickCadence(monName string, live bool, sinceChange time.Duration) (string, time.Duration) {
+	tier := pickTier(live, sinceChange)
+	every := TierCadence(monName, tier)
+	if floor := cacheFloor(monName, tier); every < floor {
+		every = floor
+		if max := viper.GetDuration("cadence.max"); max > 0 && every > max {
+			every = max
+		}
+	}
+	return tier, every
+}
+
+//evictHot drops the monitor's cached data - it was cached with the non-live ttl, so without this the hot cadence
+// would keep re-reading the same snapshot until it expired
+func evictHot(ctx context.Context, m Monitor) error {
+	cm, ok := m.(cachedMonitor)
+	gca := gcache.GlobalCache()
+	if !ok || gca == nil {
+		return nil
+	}
+	for _, group := range cadenceGroups[m.GetBase().MonitorName] {
+		if err := gca.Evict(ctx, group, cm.CacheKey()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//DueKeys checks which of the monitor keys are due for an update - unscheduled ones are due
+// Due keys are pushed out by cadence.lease so the next tick doesn't queue them again while they're updating
+func DueKeys(ctx context.Context, monName string, monKeys []string) (map[string]bool, error) {
+	ret := make(map[string]bool, len(monKeys))
+	if len(monKeys) == 0 {
+		return ret, nil
+	}
+
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	zKey := dueKey(monName)
+	pipe := rClient.Pipeline()
+	cmds := make([]*redis.FloatCmd, len(monKeys))
+	for i, key := range monKeys {
+		cmds[i] = pipe.ZScore(ctx, zKey, key)
+	}
+	// Missing members give redis.Nil - checked per command below
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	now := time.Now()
+	lease := float64(now.Add(viper.GetDuration("cadence.lease")).UnixMilli())
+	leased := make([]*redis.Z, 0)
+	for i, cmd := range cmds {
+		score, err := cmd.Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		if err == nil && score > float64(now.UnixMilli()) {
+			continue
+		}
+		ret[monKeys[i]] = true
+		leased = append(leased, &redis.Z{Score: lease, Member: monKeys[i]})
+	}
+
+	if len(leased) > 0 {
+		if err := rClient.ZAdd(ctx, zKey, leased...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+//UpdateCadence records the monitor's activity then schedules its next update based on its tier
+func UpdateCadence(ctx context.Context, m Monitor, act Activity) (string, time.Duration, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now()
+	monName := m.GetBase().MonitorName
+	aKey := activityKey(m.MonitorKey())
+
+	last, err := rClient.HGetAll(ctx, aKey).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	total := strconv.FormatFloat(act.Total, 'f', -1, 64)
+	changed := now
+	if last["total"] == total {
+		// Same total as last time - keep when it last changed
+		if ms, err := strconv.ParseInt(last["changed"], 10, 64); err == nil {
+			changed = time.UnixMilli(ms)
+		}
+	}
+
+	tier, every := pickCadence(monName, act.Live, now.Sub(changed))
+
+	pipe := rClient.TxPipeline()
+	pipe.HSet(ctx, aKey, "total", total, "changed", changed.UnixMilli(), "tier", tier)
+	pipe.Expire(ctx, aKey, viper.GetDuration("cadence.activity.ttl"))
+	pipe.ZAdd(ctx, dueKey(monName), &redis.Z{
+		Score:  float64(now.Add(every).UnixMilli()),
+		Member: m.MonitorKey(),
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", 0, err
+	}
+
+	if tier == TierHot && !act.Live {
+		// Not worth failing the update over - it's just polled with older data
+		if err := evictHot(ctx, m); err != nil {
+			df.Log.WithError(err).WithField("monitor.key", m.MonitorKey()).Warn("Problem evicting hot monitor's cached data")
+		}
+	}
+	return tier, every, nil
+}
@@ -0,0 +1,33 @@
+package mondb
+
+import (
+	"github.com/fragforce/fragevents/lib/df"
+	"testing"
+	"time"
+)
+
+func TestPickCadence(t *testing.T) {
+	tests := []struct {
+		name        string
+		monName     string
+		live        bool
+		sinceChange time.Duration
+		tier        string
+		every       time.Duration
+	}{
+		{name: "live", monName: df.MonitorNameTeam, live: true, sinceChange: time.Hour * 5, tier: TierHot, every: time.Minute * 2},
+		{name: "total just changed", monName: df.MonitorNameTeam, sinceChange: 0, tier: TierHot, every: time.Minute * 2},
+		{name: "participant total just changed", monName: df.MonitorNameParticipant, sinceChange: time.Minute, tier: TierHot, every: time.Minute * 2},
+		{name: "event total just changed", monName: df.MonitorNameEvent, sinceChange: time.Minute, tier: TierHot, every: time.Minute * 2},
+		{name: "normal", monName: df.MonitorNameTeam, sinceChange: time.Hour, tier: TierNormal, every: time.Minute * 30},
+		{name: "idle", monName: df.MonitorNameTeam, sinceChange: time.Hour * 3, tier: TierIdle, every: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier, every := pickCadence(tt.monName, tt.live, tt.sinceChange)
+			if tier != tt.tier || every != tt.every {
+				t.Errorf("got %s every %v, want %s every %v", tier, every, tt.tier, tt.every)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package mondb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/gcache"
+	"github.com/fragforce/fragevents/lib/kdb"
+	"github.com/fragforce/fragevents/lib/tracing"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"text/template"
+	"time"
+)
+
+func init() {
+	// Keep to things that are immutable for the event - the event-info topic is compacted on it
+	viper.SetDefault("event.monitor.template", `{{ .Platform }}-{{ .EventID }}`)
+}
+
+// eventKeyData is what the event key template runs against - the platform is the monitor's, so it's set even for
+// values cached without one
+type eventKeyData struct {
+	*df.CachedEvent
+	Platform  string
+	KeyPrefix string // Empty for the default platform, otherwise the platform and a -
+}
+
+func (t *EventMonitor) GetKey() string {
+	return fmt.Sprintf("%d", t.EventID)
+}
+
+func (t *EventMonitor) MonitorKey() string {
+	return t.MakeKey(t.platformKey(t.GetKey())...)
+}
+
+//CacheKey is the groupcache key for the event
+func (t *EventMonitor) CacheKey() string {
+	return t.cacheKey(t.GetKey())
+}
+
+//KafkaKeyForEventInfo is used in kafka for identity - For event-info topic (compacted)
+func (t *EventMonitor) KafkaKeyForEventInfo(event *df.CachedEvent) ([]byte, error) {
+	log := df.Log.WithFields(logrus.Fields{
+		"event.id":            event.EventID,
+		"donordrive.platform": event.Platform,
+		"last-refresh":        event.GetFetchedAt(),
+	})
+
+	tplate, err := template.New(df.TextTemplateEventMonitor).Parse(viper.GetString("event.monitor.template"))
+	if err != nil {
+		log.WithError(err).Error("Problem parsing event monitor template")
+		return nil, err
+	}
+
+	bf := new(bytes.Buffer)
+	if err := tplate.Execute(bf, eventKeyData{CachedEvent: event, Platform: t.GetPlatform(), KeyPrefix: t.kafkaKeyPrefix()}); err != nil {
+		log.WithError(err).Error("Problem executing event monitor template")
+		return nil, err
+	}
+	return bf.Bytes(), nil
+}
+
+//KafkaHeaders are used in kafka for info, routing, and debugging
+func (t *EventMonitor) KafkaHeaders(event *df.CachedEvent) []kafka.Header {
+	ret := append([]kafka.Header{t.platformHeader()}, t.metaHeaders()...)
+	ret = append(ret,
+		kafka.Header{
+			Key:   df.KHeaderKeyEventID,
+			Value: []byte(fmt.Sprintf("%d", event.EventID)),
+		},
+		kafka.Header{
+			Key:   df.KHeaderKeyTeamCount,
+			Value: []byte(fmt.Sprintf("%d", event.TeamCount)),
+		},
+	)
+	if event.Event != nil && event.Event.Name != "" {
+		ret = append(ret, kafka.Header{
+			Key:   df.KHeaderKeyEventName,
+			Value: []byte(event.Event.Name),
+		})
+	}
+	ret = append(ret, kafka.Header{
+		Key:   df.KHeaderKeyFetchedAt,
+		Value: []byte(event.GetFetchedAt()),
+	})
+	return ret
+}
+
+//MakeEventInfoMessages creates the kafka message(s) for the given event - event-info topic
+func (t *EventMonitor) MakeEventInfoMessages(event *df.CachedEvent) ([]kafka.Message, error) {
+	key, err := t.KafkaKeyForEventInfo(event)
+	if err != nil {
+		return nil, err
+	}
+	return []kafka.Message{
+		{
+			Key:     key,
+			Value:   event.RawData,
+			Headers: t.KafkaHeaders(event),
+		},
+	}, nil
+}
+
+//SetUpdateMonitoring turns on monitoring for event.active period
+func (t *EventMonitor) SetUpdateMonitoring(ctx context.Context) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	// Indexed by expiry so listing only has to look at live monitors
+	return GetStore().Save(ctx, indexKey(t.MonitorName), t.MonitorKey(), data, t.windowTTL(viper.GetDuration("event.active"), time.Now()))
+}
+
+//AmMonitoring are we monitoring this id right now - loads the stored monitor's metadata if it exists
+func (t *EventMonitor) AmMonitoring(ctx context.Context) (bool, error) {
+	loaded, err := t.Load(ctx)
+	if err != nil {
+		return false, err
+	}
+	return loaded && t.InWindow(time.Now()), nil
+}
+
+//Load fills in the monitor from redis - false if it's not there
+func (t *EventMonitor) Load(ctx context.Context) (bool, error) {
+	return loadMonitor(ctx, t.MonitorKey(), t)
+}
+
+//GetAllEvents returns a list of all monitored events that are in their window
+func GetAllEvents(ctx context.Context) ([]*EventMonitor, error) {
+	return getEvents(ctx, true)
+}
+
+//EachEventBatch calls fn with the monitored events that are in their window - at most monitor.list.batch at a time
+func EachEventBatch(ctx context.Context, fn func([]*EventMonitor) error) error {
+	return eachMonitorBatch(ctx, df.MonitorNameEvent, true, NewEventMonitorFromJSON, fn)
+}
+
+//getEvents lists the stored event monitors - activeOnly skips ones outside of their window
+func getEvents(ctx context.Context, activeOnly bool) ([]*EventMonitor, error) {
+	return listMonitors(ctx, df.MonitorNameEvent, activeOnly, NewEventMonitorFromJSON)
+}
+
+//GetEvent gets the cached event info, including its teams
+func (t *EventMonitor) GetEvent(ctx context.Context) (*df.CachedEvent, error) {
+	log := df.Log.WithFields(logrus.Fields{
+		"event.id":            t.EventID,
+		"donordrive.platform": t.GetPlatform(),
+	})
+	gca := gcache.GlobalCache()
+
+	log.Trace("Kicking off cache get/fill")
+	data, err := gca.Fetch(ctx, gcache.GroupELEvent, t.CacheKey())
+	if err != nil {
+		log.WithError(err).Error("Couldn't get entry from event's group cache")
+		return nil, err
+	}
+
+	log.Trace("Unmarshalling")
+	// While we could get away without this, let's be sure the schema is right - security :)
+	event := df.CachedEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.WithError(err).Error("Couldn't unmarshal event")
+		return nil, err
+	}
+	event.RawData = data // Set late
+
+	return &event, nil
+}
+
+//WriteEventToKafka writes the event info into the compacted event-info topic
+func (t *EventMonitor) WriteEventToKafka(ctx context.Context, event *df.CachedEvent) error {
+	log := df.Log.WithFields(logrus.Fields{
+		"event.id":            t.EventID,
+		"donordrive.platform": t.GetPlatform(),
+		"topic.event-info":    kdb.MakeTopicName(df.KTopicEventInfo),
+	})
+
+	kWrite, err := kdb.W.Get(ctx, kdb.MakeTopicName(df.KTopicEventInfo))
+	if err != nil {
+		log.WithError(err).Error("Problem getting kafka writer for event info")
+		return err
+	}
+
+	msgs, err := t.MakeEventInfoMessages(event)
+	if err != nil {
+		log.WithError(err).Error("Problem making kafka message(s)")
+		return err
+	}
+	tracing.InjectKafka(ctx, msgs)
+	c1, can1 := context.WithTimeout(ctx, time.Second*120)
+	defer can1()
+	if err := kWrite.WriteMessages(
+		c1,
+		msgs...,
+	); err != nil {
+		log.WithError(err).Error("Problem writing messages to kafka event-info topic")
+		return err
+	}
+	return nil
+}
+
+//MonitorTeams marks all the event's teams as monitored for at least event.team.active - returns how many were marked
+// New team monitors get the event's labels
+func (t *EventMonitor) MonitorTeams(ctx context.Context, event *df.CachedEvent) (int, error) {
+	cnt := 0
+	for _, team := range event.Teams {
+		if team.TeamID == nil || *team.TeamID == 0 {
+			continue
+		}
+		tm := NewTeamMonitor(t.Platform, *team.TeamID) // Teams are always on the event's platform
+		if err := tm.ExtendUpdateMonitoring(ctx, viper.GetDuration("event.team.active"), t); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
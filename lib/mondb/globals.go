@@ -2,22 +2,30 @@ package mondb
 
 import (
 	"fmt"
+	"github.com/fragforce/fragevents/lib/ddrive"
 	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/gcache"
 	"github.com/go-redis/redis/v8"
+	"github.com/segmentio/kafka-go"
 	"github.com/spf13/viper"
 	"strings"
 	"time"
 )
 
 const (
+	// Sets of monitor keys from before the expiry index - only read to move them into it
 	TeamMonitorIDSet        = "id-set"
 	ParticipantMonitorIDSet = "id-set"
+	EventMonitorIDSet       = "id-set"
+	MonitorIndex            = "expiry-index" // Sorted set of monitor keys by when they expire
 )
 
 func init() {
 	viper.SetDefault("team.active", time.Hour*24)
 	viper.SetDefault("participant.active", time.Hour*24)
 	viper.SetDefault("participant.team.active", time.Hour*1) // How long to mark the participant as active if it's team is monitored atm
+	viper.SetDefault("event.active", time.Hour*24*3)
+	viper.SetDefault("event.team.active", time.Hour*1) // How long to mark teams found in a monitored event as active
 }
 
 //GetRedisClient get our redis client
@@ -30,6 +38,44 @@ func GetRedisClient() (*redis.Client, error) {
 	return df.QuickClient(df.RPoolMonitoring, true)
 }
 
+//GetPlatform gets the monitor's donordrive platform - monitors from before platforms existed are on the default one
+func (m *BaseMonitor) GetPlatform() string {
+	if m.Platform == "" {
+		return ddrive.DefaultPlatform()
+	}
+	return m.Platform
+}
+
+//platformKey builds the id part of redis keys - the default platform isn't prefixed so existing monitors keep their keys
+func (m *BaseMonitor) platformKey(id string) []string {
+	if platform := m.GetPlatform(); platform != ddrive.DefaultPlatform() {
+		return []string{platform, id}
+	}
+	return []string{id}
+}
+
+//kafkaKeyPrefix goes in front of the compacted topic keys - the default platform isn't prefixed so existing records keep
+// their keys, same as platformKey
+func (m *BaseMonitor) kafkaKeyPrefix() string {
+	if platform := m.GetPlatform(); platform != ddrive.DefaultPlatform() {
+		return platform + "-"
+	}
+	return ""
+}
+
+//cacheKey is the platform namespaced groupcache key for the id
+func (m *BaseMonitor) cacheKey(id string) string {
+	return gcache.PlatformKey(m.GetPlatform(), id)
+}
+
+//platformHeader is the kafka header for the monitor's platform
+func (m *BaseMonitor) platformHeader() kafka.Header {
+	return kafka.Header{
+		Key:   df.KHeaderKeyPlatform,
+		Value: []byte(m.GetPlatform()),
+	}
+}
+
 func (m *BaseMonitor) MakeKey(key ...string) string {
 	return MakeKey(m.MonitorName, key...)
 }
@@ -0,0 +1,52 @@
+package mondb
+
+import (
+	"github.com/fragforce/fragevents/lib/ddrive"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/ptdave20/donordrive"
+	"testing"
+)
+
+func TestKafkaKeys(t *testing.T) {
+	testStore(t, nil)
+	def := ddrive.DefaultPlatform()
+	teamID, eventID := 1234, 500
+
+	tests := []struct {
+		name     string
+		platform string // Monitor's
+		cached   string // Cached value's - empty for ones cached before platforms existed
+		team     string
+		part     string
+		event    string
+	}{
+		{name: "default platform", platform: def, cached: def, team: "1234-500", part: "10-500", event: def + "-500"},
+		{name: "monitor from before platforms", platform: "", cached: "", team: "1234-500", part: "10-500", event: def + "-500"},
+		{name: "other platform", platform: "other", cached: "other", team: "other-1234-500", part: "other-10-500", event: "other-500"},
+		{name: "cached without platform", platform: "other", cached: "", team: "other-1234-500", part: "other-10-500", event: "other-500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewTeamMonitor(tt.platform, teamID).TeamKafkaKeyTeams(&df.CachedTeam{
+				Team:     donordrive.Team{TeamID: &teamID, EventID: &eventID},
+				Platform: tt.cached,
+			})
+			if err != nil || string(key) != tt.team {
+				t.Errorf("got team key %q (%v), want %q", key, err, tt.team)
+			}
+
+			key, err = NewParticipantMonitor(tt.platform, 10).KafkaKeyForParticipants(&df.CachedParticipant{
+				Participant: donordrive.Participant{ParticipantId: 10, EventId: eventID},
+				Platform:    tt.cached,
+			})
+			if err != nil || string(key) != tt.part {
+				t.Errorf("got participant key %q (%v), want %q", key, err, tt.part)
+			}
+
+			key, err = NewEventMonitor(tt.platform, eventID).KafkaKeyForEventInfo(&df.CachedEvent{EventID: eventID, Platform: tt.cached})
+			if err != nil || string(key) != tt.event {
+				t.Errorf("got event key %q (%v), want %q", key, err, tt.event)
+			}
+		})
+	}
+}
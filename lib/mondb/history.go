@@ -0,0 +1,211 @@
+package mondb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/gcache"
+	"github.com/spf13/viper"
+	"strings"
+	"time"
+)
+
+const (
+	HistoryRaw = "raw" // Resolution for every recorded point
+)
+
+var (
+	ErrInvalidResolution = errors.New("invalid history resolution")
+	ErrTooManyPoints     = errors.New("too many history points")
+	// Finest first - raw keeps every point, the others keep the last point in each bucket
+	historyTiers = []historyTier{
+		{Name: HistoryRaw},
+		{Name: "5m", Res: time.Minute * 5},
+		{Name: "1h", Res: time.Hour},
+	}
+)
+
+// HistoryPoint is a snapshot of a team's or participant's totals
+type HistoryPoint struct {
+	At     time.Time `json:"at"` // When it was fetched from DonorDrive
+	Raised float64   `json:"raised"`
+	Goal   *float64  `json:"goal,omitempty"`
+	Count  *int      `json:"count,omitempty"` // Number of donations
+}
+
+// History is a series of points for a team or participant
+type History struct {
+	MonitorName string          `json:"type"`
+	Platform    string          `json:"platform"`
+	ID          int             `json:"id"`
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	Resolution  string          `json:"resolution"` // What was served - coarser than asked for when the finer tier has aged out
+	Source      string          `json:"source"`     // Stored series the points came from
+	Points      []*HistoryPoint `json:"points"`
+}
+
+type historyTier struct {
+	Name string
+	Res  time.Duration // Zero for raw
+}
+
+func init() {
+	viper.SetDefault("history.raw.retention", time.Hour*48)
+	viper.SetDefault("history.5m.retention", time.Hour*24*30)
+	viper.SetDefault("history.1h.retention", time.Hour*24*400)
+	viper.SetDefault("history.points.max", 2000) // Most points returned for one request
+}
+
+func (h historyTier) retention() time.Duration {
+	return viper.GetDuration(fmt.Sprintf("history.%s.retention", h.Name))
+}
+
+//historyKey is the series for the tier - points scored by time (raw) or bucket start (others) in unix ms
+func historyKey(monName string, platform string, id int, tier historyTier) string {
+	return fmt.Sprintf("history-%s-%s-%s", monName, gcache.PlatformKey(platform, fmt.Sprintf("%d", id)), tier.Name)
+}
+
+//RecordHistory adds the point to the team's or participant's history (monName is e.g. df.MonitorNameTeam)
+// Re-recording the same fetch is a no-op, so it's fine to call on every update
+func RecordHistory(ctx context.Context, monName string, platform string, id int, point *HistoryPoint) error {
+	data, err := json.Marshal(point)
+	if err != nil {
+		return err
+	}
+
+	st := GetStore()
+	now := time.Now()
+	for _, tier := range historyTiers {
+		score := point.At.UnixMilli()
+		if tier.Res > 0 {
+			// Last point in the bucket wins - totals only go up so it's the one to keep
+			score = point.At.Truncate(tier.Res).UnixMilli()
+		}
+		keepFrom := now.Add(-tier.retention()).UnixMilli()
+		if err := st.AddPoint(ctx, historyKey(monName, platform, id, tier), score, data, tier.Res > 0, keepFrom, tier.retention()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//ParseResolution parses a history resolution - HistoryRaw or a duration of at least a minute
+func ParseResolution(res string) (time.Duration, error) {
+	if res == HistoryRaw {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(res)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidResolution, res)
+	}
+	return d, nil
+}
+
+//ResolutionName is the short form of a resolution, same as the tier names - e.g. 5m or 1h30m, HistoryRaw for 0
+func ResolutionName(res time.Duration) string {
+	if res <= 0 {
+		return HistoryRaw
+	}
+	name := res.String()
+	if strings.HasSuffix(name, "m0s") {
+		name = strings.TrimSuffix(name, "0s")
+	}
+	if strings.HasSuffix(name, "h0m") {
+		name = strings.TrimSuffix(name, "0m")
+	}
+	return name
+}
+
+//pickHistoryTier finds the coarsest stored tier that's still fine enough for res and still has from - falls back to
+// the finest one that still has from, then to the one that goes back the furthest
+func pickHistoryTier(from time.Time, res time.Duration, now time.Time) historyTier {
+	var ret, coarser *historyTier
+	for i := range historyTiers {
+		tier := historyTiers[i]
+		if now.Add(-tier.retention()).After(from) {
+			continue
+		}
+		if tier.Res > res {
+			if coarser == nil {
+				coarser = &historyTiers[i]
+			}
+			continue
+		}
+		ret = &historyTiers[i]
+	}
+	if ret != nil {
+		return *ret
+	}
+	if coarser != nil {
+		return *coarser
+	}
+
+	longest := historyTiers[0]
+	for _, tier := range historyTiers[1:] {
+		if tier.retention() > longest.retention() {
+			longest = tier
+		}
+	}
+	return longest
+}
+
+//GetHistory gets the points between from and to, downsampled to res (0 for raw) - last point in each bucket
+func GetHistory(ctx context.Context, monName string, platform string, id int, from time.Time, to time.Time, res time.Duration) (*History, error) {
+	max := viper.GetInt64("history.points.max")
+	if res > 0 && int64(to.Sub(from)/res) > max {
+		return nil, fmt.Errorf("%w: more than %d at %v", ErrTooManyPoints, max, res)
+	}
+
+	tier := pickHistoryTier(from, res, time.Now())
+	minScore := from.UnixMilli()
+	if tier.Res > 0 {
+		minScore = from.Truncate(tier.Res).UnixMilli() // Include the bucket from is in
+	}
+	members, err := GetStore().RangePoints(ctx, historyKey(monName, platform, id, tier), minScore, to.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	served := res
+	if tier.Res > res {
+		served = tier.Res // The finer tiers don't go back to from
+	}
+	ret := &History{
+		MonitorName: monName,
+		Platform:    platform,
+		ID:          id,
+		From:        from.UTC(),
+		To:          to.UTC(),
+		Resolution:  ResolutionName(served),
+		Source:      tier.Name,
+		Points:      make([]*HistoryPoint, 0, len(members)),
+	}
+
+	var lastBucket int64 = -1
+	for _, member := range members {
+		point := HistoryPoint{}
+		if err := json.Unmarshal(member, &point); err != nil {
+			return nil, err
+		}
+		if point.At.Before(from) || point.At.After(to) {
+			continue // From a bucket that straddles the range
+		}
+		if res > tier.Res {
+			// Members are in time order, so a later point in the same bucket replaces the earlier one
+			bucket := point.At.Truncate(res).UnixMilli()
+			if bucket == lastBucket {
+				ret.Points[len(ret.Points)-1] = &point
+				continue
+			}
+			lastBucket = bucket
+		}
+		ret.Points = append(ret.Points, &point)
+	}
+
+	if int64(len(ret.Points)) > max {
+		return nil, fmt.Errorf("%w: more than %d - use a coarser resolution", ErrTooManyPoints, max)
+	}
+	return ret, nil
+}
@@ -0,0 +1,203 @@
+package mondb
+
+import (
+	"context"
+	"errors"
+	"github.com/fragforce/fragevents/lib/df"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		res  string
+		want time.Duration
+		err  error
+	}{
+		{res: HistoryRaw, want: 0},
+		{res: "15m", want: time.Minute * 15},
+		{res: "24h", want: time.Hour * 24},
+		{res: "1m", want: time.Minute},
+		{res: "30s", err: ErrInvalidResolution},
+		{res: "soon", err: ErrInvalidResolution},
+		{res: "", err: ErrInvalidResolution},
+	}
+	for _, tt := range tests {
+		t.Run(tt.res, func(t *testing.T) {
+			got, err := ParseResolution(tt.res)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolutionName(t *testing.T) {
+	tests := []struct {
+		res  time.Duration
+		want string
+	}{
+		{res: 0, want: HistoryRaw},
+		{res: time.Minute, want: "1m"},
+		{res: time.Minute * 5, want: "5m"},
+		{res: time.Minute * 90, want: "1h30m"},
+		{res: time.Hour, want: "1h"},
+		{res: time.Hour * 24, want: "24h"},
+		{res: time.Minute + time.Second*30, want: "1m30s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := ResolutionName(tt.res); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+	// Tier names are the same form, so a served tier reads the same as asking for it
+	for _, tier := range historyTiers {
+		if got := ResolutionName(tier.Res); got != tier.Name {
+			t.Errorf("tier %s is named %s", tier.Name, got)
+		}
+	}
+}
+
+func TestPickHistoryTier(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		ago  time.Duration
+		res  time.Duration
+		want string
+	}{
+		{name: "raw", ago: time.Hour, res: 0, want: HistoryRaw},
+		{name: "under a bucket", ago: time.Hour, res: time.Minute, want: HistoryRaw},
+		{name: "exact tier", ago: time.Hour, res: time.Minute * 5, want: "5m"},
+		{name: "between tiers", ago: time.Hour, res: time.Minute * 30, want: "5m"},
+		{name: "coarse", ago: time.Hour, res: time.Hour * 24, want: "1h"},
+		{name: "raw aged out", ago: time.Hour * 72, res: 0, want: "5m"},
+		{name: "5m aged out", ago: time.Hour * 24 * 60, res: time.Minute * 5, want: "1h"},
+		{name: "older than everything", ago: time.Hour * 24 * 500, res: 0, want: "1h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickHistoryTier(now.Add(-tt.ago), tt.res, now); got.Name != tt.want {
+				t.Errorf("got %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, nil)
+
+	// A point a minute for 90 minutes, raised going up by one each time
+	base := time.Now().Truncate(time.Hour).Add(-time.Hour * 2)
+	for i := 0; i < 90; i++ {
+		count := i
+		point := &HistoryPoint{At: base.Add(time.Minute * time.Duration(i)), Raised: float64(i), Count: &count}
+		if err := RecordHistory(ctx, df.MonitorNameTeam, "", 1, point); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Re-recording the same fetch doesn't add a point
+	count := 89
+	if err := RecordHistory(ctx, df.MonitorNameTeam, "", 1, &HistoryPoint{At: base.Add(time.Minute * 89), Raised: 89, Count: &count}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		from   time.Time
+		to     time.Time
+		res    time.Duration
+		want   []float64
+		resStr string
+		source string
+	}{
+		{
+			name:   "raw",
+			from:   base.Add(time.Minute * 8),
+			to:     base.Add(time.Minute * 12),
+			want:   []float64{8, 9, 10, 11, 12},
+			resStr: HistoryRaw,
+			source: HistoryRaw,
+		},
+		{
+			name:   "stored tier",
+			from:   base,
+			to:     base.Add(time.Minute * 30),
+			res:    time.Minute * 5,
+			want:   []float64{4, 9, 14, 19, 24, 29},
+			resStr: "5m",
+			source: "5m",
+		},
+		{
+			name:   "downsampled",
+			from:   base,
+			to:     base.Add(time.Minute * 30),
+			res:    time.Minute * 15,
+			want:   []float64{14, 29},
+			resStr: "15m",
+			source: "5m",
+		},
+		{
+			name:   "from inside a bucket",
+			from:   base.Add(time.Minute * 7),
+			to:     base.Add(time.Minute * 20),
+			res:    time.Minute * 5,
+			want:   []float64{9, 14, 19},
+			resStr: "5m",
+			source: "5m",
+		},
+		{
+			name:   "re-recorded",
+			from:   base.Add(time.Minute * 85),
+			to:     base.Add(time.Minute * 90),
+			want:   []float64{85, 86, 87, 88, 89},
+			resStr: HistoryRaw,
+			source: HistoryRaw,
+		},
+		{
+			name:   "raw aged out",
+			from:   time.Now().Add(-time.Hour * 72),
+			to:     base.Add(time.Minute * 10),
+			res:    0,
+			want:   []float64{4, 9},
+			resStr: "5m",
+			source: "5m",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetHistory(ctx, df.MonitorNameTeam, "", 1, tt.from, tt.to, tt.res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raised := make([]float64, 0, len(got.Points))
+			for _, point := range got.Points {
+				raised = append(raised, point.Raised)
+				if point.Count == nil || float64(*point.Count) != point.Raised {
+					t.Errorf("point %v has count %v", point.Raised, point.Count)
+				}
+			}
+			if !reflect.DeepEqual(raised, tt.want) {
+				t.Errorf("got %v, want %v", raised, tt.want)
+			}
+			if got.Resolution != tt.resStr || got.Source != tt.source {
+				t.Errorf("got resolution %s from %s, want %s from %s", got.Resolution, got.Source, tt.resStr, tt.source)
+			}
+		})
+	}
+}
+
+func TestGetHistoryTooManyPoints(t *testing.T) {
+	testStore(t, nil)
+	now := time.Now()
+	if _, err := GetHistory(context.Background(), df.MonitorNameTeam, "", 1, now.Add(-time.Hour*24*30), now, time.Minute); !errors.Is(err, ErrTooManyPoints) {
+		t.Errorf("got %v, want %v", err, ErrTooManyPoints)
+	}
+}
@@ -0,0 +1,210 @@
+package mondb
+
+import (
+	"context"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sync"
+	"time"
+)
+
+var (
+	adoptedIDSets sync.Map // Monitor names whose legacy id-set has been moved into the index
+)
+
+func init() {
+	viper.SetDefault("monitor.list.batch", 500) // Most monitors to fetch per round trip when listing
+}
+
+//indexKey is the expiry index for the monitor type
+func indexKey(monName string) string {
+	return GetLookupKey(monName, MonitorIndex)
+}
+
+//eachStoredBatch walks the monitor type's index, soonest to expire first, calling fn with the stored data for up to
+// monitor.list.batch monitors at a time. Expired and missing monitors are dropped from the index and their cadence as
+// it goes.
+func eachStoredBatch(ctx context.Context, monName string, fn func(data [][]byte) error) error {
+	log := df.Log.WithField("monitor.name", monName)
+	st := GetStore()
+	index := indexKey(monName)
+	log = log.WithField("index.key", index)
+
+	if err := adoptIDSet(ctx, monName); err != nil {
+		log.WithError(err).Error("Problem moving legacy id set into the index")
+		return err
+	}
+
+	pruned, err := st.Prune(ctx, index)
+	if err != nil {
+		log.WithError(err).Error("Problem pruning expired monitors")
+		return err
+	}
+
+	size := viper.GetInt64("monitor.list.batch")
+	if size <= 0 {
+		size = 500
+	}
+
+	missing := make([]string, 0)
+	total := 0
+	// A cursor rather than an offset, so monitors expiring mid-walk don't shift the rest along and get them skipped
+	cursor := IndexCursor{}
+	for {
+		keys, next, err := st.Scan(ctx, index, cursor, size)
+		if err != nil {
+			log.WithError(err).Error("Problem scanning monitor index")
+			return err
+		}
+		if len(keys) == 0 {
+			break
+		}
+
+		data, err := st.GetMany(ctx, keys)
+		if err != nil {
+			log.WithError(err).Error("Problem getting monitors")
+			return err
+		}
+		batch := make([][]byte, 0, len(data))
+		for i, d := range data {
+			if d == nil {
+				missing = append(missing, keys[i])
+				continue
+			}
+			batch = append(batch, d)
+		}
+		total += len(batch)
+
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+		cursor = next
+	}
+
+	if len(missing) > 0 {
+		if err := st.Unindex(ctx, index, missing...); err != nil {
+			log.WithError(err).Error("Problem dropping missing monitors from the index")
+			return err
+		}
+	}
+	pruned = append(pruned, missing...)
+	if len(pruned) > 0 {
+		// No point scheduling updates for them anymore
+		if err := st.Unindex(ctx, dueKey(monName), pruned...); err != nil {
+			log.WithError(err).Error("Problem dropping pruned monitors from the cadence")
+			return err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"monitors.count":  total,
+		"monitors.pruned": len(pruned),
+	}).Trace("Listed monitors")
+	return nil
+}
+
+//eachMonitorBatch decodes each batch from eachStoredBatch with parse and calls fn with it - activeOnly skips monitors
+// outside of their window, and batches left empty aren't passed on
+func eachMonitorBatch[M Monitor](ctx context.Context, monName string, activeOnly bool, parse func([]byte) (M, error), fn func([]M) error) error {
+	log := df.Log.WithField("monitor.name", monName)
+	now := time.Now()
+
+	return eachStoredBatch(ctx, monName, func(data [][]byte) error {
+		batch := make([]M, 0, len(data)) // Can't assume len - might skip some
+		for _, d := range data {
+			m, err := parse(d)
+			if err != nil {
+				log.WithError(err).Error("Problem with turning json data into monitor")
+				return err
+			}
+			// Anything stored is monitored directly - only the window is left to check
+			if activeOnly && !m.GetBase().InWindow(now) {
+				continue
+			}
+			batch = append(batch, m)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		return fn(batch)
+	})
+}
+
+//listMonitors collects every batch from eachMonitorBatch
+func listMonitors[M Monitor](ctx context.Context, monName string, activeOnly bool, parse func([]byte) (M, error)) ([]M, error) {
+	ret := make([]M, 0)
+	err := eachMonitorBatch(ctx, monName, activeOnly, parse, func(batch []M) error {
+		ret = append(ret, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+//adoptIDSet moves monitors from the set used before the expiry index existed into the index - once per type
+func adoptIDSet(ctx context.Context, monName string) error {
+	if _, ok := GetStore().(*RedisStore); !ok {
+		return nil // Only redis ever had them
+	}
+	if _, done := adoptedIDSets.Load(monName); done {
+		return nil
+	}
+
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+
+	sKey := GetLookupKey(monName, TeamMonitorIDSet) // Same for all types
+	keys, err := rClient.SMembers(ctx, sKey).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		pipe := rClient.Pipeline()
+		cmds := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.PTTL(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		members := make([]*redis.Z, 0, len(keys))
+		for i, cmd := range cmds {
+			left := cmd.Val()
+			switch left {
+			case -2: // Gone already
+				continue
+			case -1:
+				left = 0 // Never
+			}
+			members = append(members, &redis.Z{Score: indexScore(now, left), Member: keys[i]})
+		}
+
+		tx := rClient.TxPipeline()
+		if len(members) > 0 {
+			tx.ZAddNX(ctx, indexKey(monName), members...) // Don't clobber anything saved since
+		}
+		tx.Del(ctx, sKey)
+		if _, err := tx.Exec(ctx); err != nil {
+			return err
+		}
+		df.Log.WithFields(logrus.Fields{
+			"monitor.name":   monName,
+			"monitors.count": len(members),
+			"legacy.set.key": sKey,
+		}).Info("Moved legacy monitor id set into the expiry index")
+	}
+
+	adoptedIDSets.Store(monName, true)
+	return nil
+}
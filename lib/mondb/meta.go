@@ -0,0 +1,206 @@
+package mondb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/viper"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// Monitor is what all the monitor types have in common
+type Monitor interface {
+	GetBase() *BaseMonitor
+	GetKey() string
+	MonitorKey() string
+}
+
+// MonitorFilter picks monitors by their metadata - empty fields match everything
+type MonitorFilter struct {
+	Labels   []string // Must have all of these
+	Owner    string
+	Client   string
+	Platform string
+	State    string // WindowPending, WindowActive, or WindowEnded
+}
+
+var (
+	ErrInvalidLabel  = errors.New("invalid monitor label")
+	ErrTooManyLabels = errors.New("too many monitor labels")
+	ErrNoSuchMonitor = errors.New("no such monitor type")
+	labelRe          = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+)
+
+func init() {
+	viper.SetDefault("monitor.labels.max", 16)
+	viper.SetDefault("monitor.labels.length", 64)
+	viper.SetDefault("monitor.notes.length", 1024) // In characters - longer notes are cut off
+}
+
+//NewMonitorMeta creates metadata for a monitor being registered now - labels are de-duped
+func NewMonitorMeta(owner string, client string, labels []string, notes string) (MonitorMeta, error) {
+	seen := make(map[string]bool, len(labels))
+	clean := make([]string, 0, len(labels))
+	for _, label := range labels {
+		if !labelRe.MatchString(label) || len(label) > viper.GetInt("monitor.labels.length") {
+			return MonitorMeta{}, fmt.Errorf("%w: %q", ErrInvalidLabel, label)
+		}
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		clean = append(clean, label)
+	}
+	if len(clean) > viper.GetInt("monitor.labels.max") {
+		return MonitorMeta{}, ErrTooManyLabels
+	}
+	// By rune so a multi-byte character isn't split
+	if max := viper.GetInt("monitor.notes.length"); utf8.RuneCountInString(notes) > max {
+		notes = string([]rune(notes)[:max])
+	}
+
+	return MonitorMeta{
+		Owner:        owner,
+		RegisteredAt: time.Now().UTC(),
+		Client:       client,
+		Labels:       clean,
+		Notes:        notes,
+	}, nil
+}
+
+//HasLabel checks if the monitor has the given label
+func (m *MonitorMeta) HasLabel(label string) bool {
+	for _, l := range m.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+//GetBase gets the shared part of the monitor
+func (m *BaseMonitor) GetBase() *BaseMonitor {
+	return m
+}
+
+//InheritMeta copies the parent's owner, labels, notes, and milestones - for monitors started because of another monitor
+func (m *BaseMonitor) InheritMeta(parent *BaseMonitor, via string) {
+	m.MonitorMeta = MonitorMeta{
+		Owner:        parent.Owner,
+		RegisteredAt: time.Now().UTC(),
+		Client:       via,
+		Labels:       append([]string(nil), parent.Labels...),
+		Notes:        parent.Notes,
+	}
+	if parent.Milestones != nil {
+		m.Milestones = &Milestones{
+			Percents: append([]float64(nil), parent.Milestones.Percents...),
+			Amounts:  append([]float64(nil), parent.Milestones.Amounts...),
+		}
+	}
+}
+
+//metaHeaders are the kafka headers for the monitor's owner and labels - one header per label
+func (m *BaseMonitor) metaHeaders() []kafka.Header {
+	ret := make([]kafka.Header, 0, len(m.Labels)+1)
+	if m.Owner != "" {
+		ret = append(ret, kafka.Header{
+			Key:   df.KHeaderKeyOwner,
+			Value: []byte(m.Owner),
+		})
+	}
+	for _, label := range m.Labels {
+		ret = append(ret, kafka.Header{
+			Key:   df.KHeaderKeyLabel,
+			Value: []byte(label),
+		})
+	}
+	return ret
+}
+
+//Matches checks if the monitor passes the filter
+func (f *MonitorFilter) Matches(m Monitor) bool {
+	base := m.GetBase()
+	if f.Owner != "" && base.Owner != f.Owner {
+		return false
+	}
+	if f.Client != "" && base.Client != f.Client {
+		return false
+	}
+	if f.Platform != "" && base.GetPlatform() != f.Platform {
+		return false
+	}
+	if f.State != "" && base.WindowState(time.Now()) != f.State {
+		return false
+	}
+	for _, label := range f.Labels {
+		if !base.HasLabel(label) {
+			return false
+		}
+	}
+	return true
+}
+
+//loadMonitor reads the stored monitor at key into 'into' - false if it's not there
+func loadMonitor(ctx context.Context, key string, into interface{}) (bool, error) {
+	data, err := GetStore().Get(ctx, key)
+	if errors.Is(err, ErrNotStored) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, into); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//GetAllMonitors lists all monitors of the given type (e.g. df.MonitorNameTeam) that pass the filter - including ones outside their window
+func GetAllMonitors(ctx context.Context, monName string, filter *MonitorFilter) ([]Monitor, error) {
+	all := make([]Monitor, 0)
+	switch monName {
+	case df.MonitorNameTeam:
+		teams, err := getTeams(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range teams {
+			all = append(all, m)
+		}
+	case df.MonitorNameParticipant:
+		participants, err := getParticipants(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range participants {
+			all = append(all, m)
+		}
+	case df.MonitorNameEvent:
+		events, err := getEvents(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range events {
+			all = append(all, m)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchMonitor, monName)
+	}
+
+	if filter == nil {
+		return all, nil
+	}
+	ret := make([]Monitor, 0, len(all))
+	for _, m := range all {
+		if filter.Matches(m) {
+			ret = append(ret, m)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,42 @@
+package mondb
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"reflect"
+	"testing"
+)
+
+func TestNewMonitorMeta(t *testing.T) {
+	old := viper.GetInt("monitor.notes.length")
+	viper.Set("monitor.notes.length", 4)
+	t.Cleanup(func() { viper.Set("monitor.notes.length", old) })
+
+	tests := []struct {
+		name   string
+		labels []string
+		notes  string
+		want   MonitorMeta
+		err    error
+	}{
+		{name: "labels de-duped", labels: []string{"main", "guest", "main"}, want: MonitorMeta{Labels: []string{"main", "guest"}}},
+		{name: "bad label", labels: []string{"has space"}, err: ErrInvalidLabel},
+		{name: "short notes", notes: "abc", want: MonitorMeta{Labels: []string{}, Notes: "abc"}},
+		{name: "notes cut by rune", notes: "ééééé", want: MonitorMeta{Labels: []string{}, Notes: "éééé"}},
+		{name: "notes cut", notes: "abcdef", want: MonitorMeta{Labels: []string{}, Notes: "abcd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMonitorMeta("", "", tt.labels, tt.notes)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.Labels, tt.want.Labels) || got.Notes != tt.want.Notes {
+				t.Errorf("got %v %q, want %v %q", got.Labels, got.Notes, tt.want.Labels, tt.want.Notes)
+			}
+		})
+	}
+}
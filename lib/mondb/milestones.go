@@ -0,0 +1,327 @@
+package mondb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/kdb"
+	"github.com/fragforce/fragevents/lib/tracing"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	milestoneSeeded        = "seeded"   // Hash field set once a check has finished - holds the total it saw
+	milestoneReachedPrefix = "reached-" // Hash field prefix for each reached milestone's details
+)
+
+// Milestones are the thresholds a monitor celebrates crossing
+type Milestones struct {
+	Percents []float64 `json:"percents,omitempty"` // Percent of the goal - skipped if there's no goal
+	Amounts  []float64 `json:"amounts,omitempty"`  // Amount raised
+}
+
+type milestone struct {
+	Name    string
+	Percent *float64
+	Amount  float64
+}
+
+var (
+	ErrInvalidMilestone  = errors.New("invalid milestone")
+	ErrTooManyMilestones = errors.New("too many milestones")
+	// writeMilestones publishes reached milestones - a var so tests don't need kafka
+	writeMilestones = writeMilestonesToKafka
+)
+
+func init() {
+	viper.SetDefault("milestone.percents", []string{"25", "50", "75", "100"}) // For monitors that don't set their own
+	viper.SetDefault("milestone.amounts", []string{})
+	viper.SetDefault("milestone.max", 32)           // Most milestones of each kind per monitor
+	viper.SetDefault("milestone.percent.max", 1000) // Highest percent of goal allowed
+	viper.SetDefault("milestone.ttl", time.Hour*24*60)
+}
+
+//NewMilestones validates and sorts milestones for a monitor being registered - nil (use the defaults) if neither is given
+func NewMilestones(percents []float64, amounts []float64) (*Milestones, error) {
+	if len(percents) == 0 && len(amounts) == 0 {
+		return nil, nil
+	}
+	cleanP, err := cleanThresholds(percents, viper.GetFloat64("milestone.percent.max"))
+	if err != nil {
+		return nil, err
+	}
+	cleanA, err := cleanThresholds(amounts, math.MaxFloat64)
+	if err != nil {
+		return nil, err
+	}
+	return &Milestones{Percents: cleanP, Amounts: cleanA}, nil
+}
+
+//cleanThresholds sorts and de-dupes the thresholds - each has to be above 0 and at most max
+func cleanThresholds(vals []float64, max float64) ([]float64, error) {
+	seen := make(map[float64]bool, len(vals))
+	ret := make([]float64, 0, len(vals))
+	for _, v := range vals {
+		if math.IsNaN(v) || v <= 0 || v > max {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMilestone, v)
+		}
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		ret = append(ret, v)
+	}
+	if len(ret) > viper.GetInt("milestone.max") {
+		return nil, ErrTooManyMilestones
+	}
+	sort.Float64s(ret)
+	return ret, nil
+}
+
+//DefaultMilestones are used by monitors that don't set their own - bad config values are skipped
+func DefaultMilestones() Milestones {
+	parse := func(key string) []float64 {
+		ret := make([]float64, 0)
+		for _, v := range viper.GetStringSlice(key) {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+				ret = append(ret, f)
+			}
+		}
+		sort.Float64s(ret)
+		return ret
+	}
+	return Milestones{
+		Percents: parse("milestone.percents"),
+		Amounts:  parse("milestone.amounts"),
+	}
+}
+
+//GetMilestones gets the monitor's milestones, or the defaults if it doesn't have its own
+func (m *BaseMonitor) GetMilestones() Milestones {
+	if m.Milestones == nil {
+		return DefaultMilestones()
+	}
+	return *m.Milestones
+}
+
+//thresholds turns the milestones into amounts to reach - percents need a goal
+func (ms Milestones) thresholds(goal *float64) []milestone {
+	ret := make([]milestone, 0, len(ms.Percents)+len(ms.Amounts))
+	if goal != nil && *goal > 0 {
+		for i := range ms.Percents {
+			pct := ms.Percents[i]
+			ret = append(ret, milestone{
+				Name:    "percent-" + strconv.FormatFloat(pct, 'f', -1, 64),
+				Percent: &pct,
+				Amount:  *goal * pct / 100,
+			})
+		}
+	}
+	for _, amt := range ms.Amounts {
+		ret = append(ret, milestone{
+			Name:   "amount-" + strconv.FormatFloat(amt, 'f', -1, 64),
+			Amount: amt,
+		})
+	}
+	return ret
+}
+
+//milestoneKey is the hash of reached milestone details (plus if it's been seeded) for the monitor
+func milestoneKey(m Monitor) string {
+	return fmt.Sprintf("milestones-%s", m.MonitorKey())
+}
+
+//milestoneID is the same every time the milestone is published for the monitor, so consumers can de-dupe on it
+func milestoneID(platform string, rType string, id int, name string) string {
+	return fmt.Sprintf("%s-%s-%d-%s", platform, rType, id, name)
+}
+
+//CheckMilestones compares the new total with what was reached before and marks newly reached milestones - returns
+// the ones to publish. A milestone is only returned by the one call that sets its field, but ReachMilestones may
+// unmark it again. Until a check of the monitor has finished once, ones it's already past are marked as backfilled
+// and not returned.
+func CheckMilestones(ctx context.Context, m Monitor, rType string, id int, raised float64, goal *float64) ([]*df.MilestoneReached, error) {
+	st := GetStore()
+	key := milestoneKey(m)
+	ttl := viper.GetDuration("milestone.ttl")
+
+	// Only set once a check finishes, so one that fails part way still backfills next time
+	fields, err := st.GetFields(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	_, seeded := fields[milestoneSeeded]
+	first := !seeded
+
+	now := time.Now().UTC()
+	platform := m.GetBase().GetPlatform()
+	ret := make([]*df.MilestoneReached, 0)
+	for _, ms := range m.GetBase().GetMilestones().thresholds(goal) {
+		if _, ok := fields[milestoneReachedPrefix+ms.Name]; ok || raised < ms.Amount {
+			continue
+		}
+
+		reached := &df.MilestoneReached{
+			Type:        df.ChangeTypeMilestoneReached,
+			MilestoneID: milestoneID(platform, rType, id, ms.Name),
+			Milestone:   ms.Name,
+			Percent:     ms.Percent,
+			Amount:      ms.Amount,
+			Goal:        goal,
+			Raised:      raised,
+			RType:       rType,
+			ID:          id,
+			Platform:    platform,
+			At:          now,
+			Backfilled:  first,
+		}
+		data, err := json.Marshal(reached)
+		if err != nil {
+			return ret, err
+		}
+		set, err := st.SetFieldIfMissing(ctx, key, milestoneReachedPrefix+ms.Name, data, ttl)
+		if err != nil {
+			return ret, err
+		}
+		if !set {
+			continue // Another update got there first
+		}
+		if !first {
+			ret = append(ret, reached)
+		}
+	}
+
+	if err := st.SetField(ctx, key, milestoneSeeded, []byte(strconv.FormatFloat(raised, 'f', -1, 64)), ttl); err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+//unmarkMilestones forgets the milestones were reached - so they're tried again next time
+func unmarkMilestones(ctx context.Context, m Monitor, reached []*df.MilestoneReached) error {
+	fields := make([]string, 0, len(reached))
+	for _, r := range reached {
+		fields = append(fields, milestoneReachedPrefix+r.Milestone)
+	}
+	return GetStore().DeleteFields(ctx, milestoneKey(m), fields...)
+}
+
+//GetReachedMilestones lists the milestones the monitor has reached, lowest first
+func GetReachedMilestones(ctx context.Context, m Monitor) ([]*df.MilestoneReached, error) {
+	fields, err := GetStore().GetFields(ctx, milestoneKey(m))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*df.MilestoneReached, 0, len(fields))
+	for field, data := range fields {
+		if !strings.HasPrefix(field, milestoneReachedPrefix) {
+			continue
+		}
+		reached := df.MilestoneReached{}
+		if err := json.Unmarshal(data, &reached); err != nil {
+			return nil, err
+		}
+		if reached.MilestoneID == "" {
+			// Reached before it had one
+			reached.MilestoneID = milestoneID(reached.Platform, reached.RType, reached.ID, reached.Milestone)
+		}
+		ret = append(ret, &reached)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Amount == ret[j].Amount {
+			return ret[i].Milestone < ret[j].Milestone
+		}
+		return ret[i].Amount < ret[j].Amount
+	})
+	return ret, nil
+}
+
+//MilestoneKafkaHeaders are used in kafka for info, routing, and debugging
+func MilestoneKafkaHeaders(m Monitor, reached *df.MilestoneReached) []kafka.Header {
+	base := m.GetBase()
+	ret := append([]kafka.Header{base.platformHeader()}, base.metaHeaders()...)
+	idKey := df.KHeaderKeyParticipantID
+	if reached.RType == df.RTypeTeam {
+		idKey = df.KHeaderKeyTeamID
+	}
+	return append(ret,
+		kafka.Header{
+			Key:   df.KHeaderKeyChangeType,
+			Value: []byte(reached.Type),
+		},
+		kafka.Header{
+			Key:   idKey,
+			Value: []byte(fmt.Sprintf("%d", reached.ID)),
+		},
+		kafka.Header{
+			Key:   df.KHeaderKeyMilestoneID,
+			Value: []byte(reached.MilestoneID),
+		},
+	)
+}
+
+//ReachMilestones checks for newly reached milestones and publishes them to the events topic - returns how many
+// If publishing fails they're unmarked so the next update tries again. A write that errors but still made it to kafka
+// (e.g. a timeout) gets published again, so delivery is at-least-once - consumers de-dupe on the milestone id.
+func ReachMilestones(ctx context.Context, m Monitor, rType string, id int, raised float64, goal *float64) (int, error) {
+	log := df.Log.WithFields(logrus.Fields{
+		"monitor.key":  m.MonitorKey(),
+		"topic.events": kdb.MakeTopicName(df.KTopicEvents),
+	})
+
+	reached, err := CheckMilestones(ctx, m, rType, id, raised, goal)
+	if err != nil {
+		log.WithError(err).Error("Problem checking milestones")
+		// Don't leave any half done
+		if err := unmarkMilestones(ctx, m, reached); err != nil {
+			log.WithError(err).Error("Problem unmarking milestones")
+		}
+		return 0, err
+	}
+	if len(reached) == 0 {
+		return 0, nil
+	}
+
+	if err := writeMilestones(ctx, m, reached); err != nil {
+		log.WithError(err).Error("Problem writing milestones to kafka events topic")
+		if err := unmarkMilestones(ctx, m, reached); err != nil {
+			log.WithError(err).Error("Problem unmarking milestones")
+		}
+		return 0, err
+	}
+	return len(reached), nil
+}
+
+func writeMilestonesToKafka(ctx context.Context, m Monitor, reached []*df.MilestoneReached) error {
+	kWrite, err := kdb.W.Get(ctx, kdb.MakeTopicName(df.KTopicEvents))
+	if err != nil {
+		return err
+	}
+
+	msgs := make([]kafka.Message, 0, len(reached))
+	for _, r := range reached {
+		value, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:     []byte(fmt.Sprintf("%s-%s-%d", r.Platform, r.RType, r.ID)),
+			Value:   value,
+			Headers: MilestoneKafkaHeaders(m, r),
+		})
+	}
+	tracing.InjectKafka(ctx, msgs)
+	c1, can1 := context.WithTimeout(ctx, time.Second*120)
+	defer can1()
+	return kWrite.WriteMessages(c1, msgs...)
+}
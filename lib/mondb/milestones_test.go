@@ -0,0 +1,280 @@
+package mondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/spf13/viper"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestCleanThresholds(t *testing.T) {
+	tests := []struct {
+		name string
+		vals []float64
+		max  float64
+		want []float64
+		err  error
+	}{
+		{name: "sorted and de-duped", vals: []float64{75, 25, 50, 25}, max: 100, want: []float64{25, 50, 75}},
+		{name: "empty", vals: []float64{}, max: 100, want: []float64{}},
+		{name: "at max", vals: []float64{100}, max: 100, want: []float64{100}},
+		{name: "over max", vals: []float64{101}, max: 100, err: ErrInvalidMilestone},
+		{name: "zero", vals: []float64{0}, max: 100, err: ErrInvalidMilestone},
+		{name: "negative", vals: []float64{-5}, max: 100, err: ErrInvalidMilestone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanThresholds(tt.vals, tt.max)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanThresholdsTooMany(t *testing.T) {
+	old := viper.GetInt("milestone.max")
+	viper.Set("milestone.max", 2)
+	t.Cleanup(func() { viper.Set("milestone.max", old) })
+
+	if _, err := cleanThresholds([]float64{1, 2, 3}, 100); !errors.Is(err, ErrTooManyMilestones) {
+		t.Errorf("got %v, want %v", err, ErrTooManyMilestones)
+	}
+	// Dupes don't count
+	if _, err := cleanThresholds([]float64{1, 2, 2}, 100); err != nil {
+		t.Errorf("got %v for two distinct milestones", err)
+	}
+}
+
+func TestNewMilestones(t *testing.T) {
+	tests := []struct {
+		name     string
+		percents []float64
+		amounts  []float64
+		want     *Milestones
+		err      error
+	}{
+		{name: "both nil", want: nil},
+		{name: "both empty", percents: []float64{}, amounts: []float64{}, want: nil},
+		{name: "percents only", percents: []float64{50, 10}, want: &Milestones{Percents: []float64{10, 50}, Amounts: []float64{}}},
+		{name: "amounts only", amounts: []float64{500}, want: &Milestones{Percents: []float64{}, Amounts: []float64{500}}},
+		{name: "percent over max", percents: []float64{5000}, err: ErrInvalidMilestone},
+		{name: "bad amount", percents: []float64{50}, amounts: []float64{-1}, err: ErrInvalidMilestone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMilestones(tt.percents, tt.amounts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMilestoneThresholds(t *testing.T) {
+	ms := Milestones{Percents: []float64{50, 100}, Amounts: []float64{250}}
+	tests := []struct {
+		name    string
+		goal    *float64
+		names   []string
+		amounts []float64
+	}{
+		{name: "nil goal", goal: nil, names: []string{"amount-250"}, amounts: []float64{250}},
+		{name: "zero goal", goal: floatPtr(0), names: []string{"amount-250"}, amounts: []float64{250}},
+		{
+			name:    "with goal",
+			goal:    floatPtr(1000),
+			names:   []string{"percent-50", "percent-100", "amount-250"},
+			amounts: []float64{500, 1000, 250},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ms.thresholds(tt.goal)
+			names := make([]string, 0, len(got))
+			amounts := make([]float64, 0, len(got))
+			for _, m := range got {
+				names = append(names, m.Name)
+				amounts = append(amounts, m.Amount)
+				if (m.Percent != nil) != strings.HasPrefix(m.Name, "percent-") {
+					t.Errorf("%s has percent %v", m.Name, m.Percent)
+				}
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("got names %v, want %v", names, tt.names)
+			}
+			if !reflect.DeepEqual(amounts, tt.amounts) {
+				t.Errorf("got amounts %v, want %v", amounts, tt.amounts)
+			}
+		})
+	}
+}
+
+func TestDefaultMilestones(t *testing.T) {
+	tests := []struct {
+		name     string
+		percents []string
+		amounts  []string
+		want     Milestones
+	}{
+		{
+			name:     "defaults",
+			percents: []string{"25", "50", "75", "100"},
+			amounts:  []string{},
+			want:     Milestones{Percents: []float64{25, 50, 75, 100}, Amounts: []float64{}},
+		},
+		{
+			name:     "sorted",
+			percents: []string{"90", "10"},
+			amounts:  []string{"1000", "100"},
+			want:     Milestones{Percents: []float64{10, 90}, Amounts: []float64{100, 1000}},
+		},
+		{
+			name:     "bad values skipped",
+			percents: []string{"half", "-10", "0", "50"},
+			amounts:  []string{"lots"},
+			want:     Milestones{Percents: []float64{50}, Amounts: []float64{}},
+		},
+	}
+	oldP, oldA := viper.GetStringSlice("milestone.percents"), viper.GetStringSlice("milestone.amounts")
+	t.Cleanup(func() {
+		viper.Set("milestone.percents", oldP)
+		viper.Set("milestone.amounts", oldA)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Set("milestone.percents", tt.percents)
+			viper.Set("milestone.amounts", tt.amounts)
+			if got := DefaultMilestones(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInheritMetaMilestones(t *testing.T) {
+	tm := NewTeamMonitor("", 1)
+	tm.Milestones = &Milestones{Percents: []float64{10}, Amounts: []float64{100}}
+
+	pm := NewParticipantMonitor("", 10)
+	pm.InheritMeta(tm.BaseMonitor, tm.MonitorKey())
+	if !reflect.DeepEqual(pm.Milestones, tm.Milestones) {
+		t.Fatalf("got %+v, want %+v", pm.Milestones, tm.Milestones)
+	}
+	pm.Milestones.Percents[0] = 20
+	if tm.Milestones.Percents[0] != 10 {
+		t.Error("participant's milestones share the team's slice")
+	}
+
+	pm = NewParticipantMonitor("", 11)
+	pm.InheritMeta(NewTeamMonitor("", 2).BaseMonitor, "")
+	if pm.Milestones != nil {
+		t.Errorf("got %+v from a team using the defaults", pm.Milestones)
+	}
+}
+
+// fakeMilestoneWriter swaps in a writer that records what it publishes - fail makes it error instead
+func fakeMilestoneWriter(t *testing.T) (*[]string, *bool) {
+	t.Helper()
+	published, fail := make([]string, 0), false
+	old := writeMilestones
+	writeMilestones = func(ctx context.Context, m Monitor, reached []*df.MilestoneReached) error {
+		if fail {
+			return errors.New("kafka is down")
+		}
+		for _, r := range reached {
+			published = append(published, r.MilestoneID)
+		}
+		return nil
+	}
+	t.Cleanup(func() { writeMilestones = old })
+	return &published, &fail
+}
+
+func TestReachMilestones(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, nil)
+	published, fail := fakeMilestoneWriter(t)
+
+	tm := NewTeamMonitor("", 1)
+	tm.Milestones = &Milestones{Percents: []float64{25, 50, 75, 100}}
+	goal := floatPtr(1000)
+	prefix := tm.GetPlatform() + "-team-1-"
+
+	steps := []struct {
+		name      string
+		raised    float64
+		fail      bool
+		cnt       int
+		err       bool
+		published []string // Everything published so far
+	}{
+		{name: "first check backfills", raised: 600, cnt: 0, published: []string{}},
+		{name: "nothing new", raised: 700, cnt: 0, published: []string{}},
+		{name: "publishes once", raised: 800, cnt: 1, published: []string{prefix + "percent-75"}},
+		{name: "not again", raised: 900, cnt: 0, published: []string{prefix + "percent-75"}},
+		{name: "kafka down", raised: 1000, fail: true, err: true, published: []string{prefix + "percent-75"}},
+		{name: "republished after unmark", raised: 1000, cnt: 1, published: []string{prefix + "percent-75", prefix + "percent-100"}},
+		{name: "done", raised: 1100, cnt: 0, published: []string{prefix + "percent-75", prefix + "percent-100"}},
+	}
+	for _, step := range steps {
+		*fail = step.fail
+		cnt, err := ReachMilestones(ctx, tm, df.RTypeTeam, 1, step.raised, goal)
+		if (err != nil) != step.err || cnt != step.cnt {
+			t.Fatalf("%s: got %d (%v), want %d (error %v)", step.name, cnt, err, step.cnt, step.err)
+		}
+		if !reflect.DeepEqual(*published, step.published) {
+			t.Fatalf("%s: published %v, want %v", step.name, *published, step.published)
+		}
+	}
+
+	reached, err := GetReachedMilestones(ctx, tm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, 0, len(reached))
+	for _, r := range reached {
+		got = append(got, fmt.Sprintf("%s backfilled=%v", r.MilestoneID, r.Backfilled))
+	}
+	want := []string{
+		prefix + "percent-25 backfilled=true",
+		prefix + "percent-50 backfilled=true",
+		prefix + "percent-75 backfilled=false",
+		prefix + "percent-100 backfilled=false",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got reached %v, want %v", got, want)
+	}
+}
+
+func TestCheckMilestonesNoGoal(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, nil)
+
+	pm := NewParticipantMonitor("", 10)
+	pm.Milestones = &Milestones{Percents: []float64{50}, Amounts: []float64{100}}
+	if _, err := CheckMilestones(ctx, pm, df.RTypeParticipant, 10, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	reached, err := CheckMilestones(ctx, pm, df.RTypeParticipant, 10, 150, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reached) != 1 || reached[0].Milestone != "amount-100" {
+		t.Errorf("got %+v, want just amount-100", reached)
+	}
+}
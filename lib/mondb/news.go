@@ -11,20 +11,36 @@ func NewBaseMonitor(monName string) *BaseMonitor {
 	}
 }
 
-func NewTeamMonitor(teamID int) *TeamMonitor {
+func NewPlatformBaseMonitor(monName string, platform string) *BaseMonitor {
+	ret := NewBaseMonitor(monName)
+	ret.Platform = platform
+	return ret
+}
+
+//NewTeamMonitor creates a monitor for the team on the given donordrive platform - empty is the default platform
+func NewTeamMonitor(platform string, teamID int) *TeamMonitor {
 	return &TeamMonitor{
-		BaseMonitor: NewBaseMonitor(df.MonitorNameTeam),
+		BaseMonitor: NewPlatformBaseMonitor(df.MonitorNameTeam, platform),
 		TeamID:      teamID,
 	}
 }
 
-func NewParticipantMonitor(participantID int) *ParticipantMonitor {
+//NewParticipantMonitor creates a monitor for the participant on the given donordrive platform - empty is the default platform
+func NewParticipantMonitor(platform string, participantID int) *ParticipantMonitor {
 	return &ParticipantMonitor{
-		BaseMonitor:   NewBaseMonitor(df.MonitorNameParticipant),
+		BaseMonitor:   NewPlatformBaseMonitor(df.MonitorNameParticipant, platform),
 		ParticipantID: participantID,
 	}
 }
 
+//NewEventMonitor creates a monitor for the event on the given donordrive platform - empty is the default platform
+func NewEventMonitor(platform string, eventID int) *EventMonitor {
+	return &EventMonitor{
+		BaseMonitor: NewPlatformBaseMonitor(df.MonitorNameEvent, platform),
+		EventID:     eventID,
+	}
+}
+
 func NewTeamMonitorFromJSON(data []byte) (*TeamMonitor, error) {
 	ret := TeamMonitor{}
 	if err := json.Unmarshal(data, &ret); err != nil {
@@ -33,6 +49,14 @@ func NewTeamMonitorFromJSON(data []byte) (*TeamMonitor, error) {
 	return &ret, nil
 }
 
+func NewEventMonitorFromJSON(data []byte) (*EventMonitor, error) {
+	ret := EventMonitor{}
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
 func NewParticipantMonitorFromJSON(data []byte) (*ParticipantMonitor, error) {
 	ret := ParticipantMonitor{}
 	if err := json.Unmarshal(data, &ret); err != nil {
@@ -4,13 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/gcache"
 	"github.com/fragforce/fragevents/lib/kdb"
-	"github.com/go-redis/redis/v8"
-	"github.com/mailgun/groupcache/v2"
+	"github.com/fragforce/fragevents/lib/tracing"
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -20,7 +18,24 @@ import (
 
 func init() {
 	// Keep to things that are immutable for the team
-	viper.SetDefault("participant.monitor.template", `{{ .ParticipantId }}-{{ .EventId }}`)
+	viper.SetDefault("participant.monitor.template", `{{ .KeyPrefix }}{{ .ParticipantId }}-{{ .EventId }}`)
+}
+
+// participantKeyData is what the participant key template runs against - the platform is the monitor's, as
+// participants cached before platforms existed don't have one
+type participantKeyData struct {
+	*df.CachedParticipant
+	Platform  string
+	KeyPrefix string // Empty for the default platform, otherwise the platform and a -
+}
+
+// participantTeamID looks up the participant's team - 0 if it isn't on one. A var so tests don't need donordrive.
+var participantTeamID = func(ctx context.Context, t *ParticipantMonitor) (int, error) {
+	p, err := t.GetParticipant(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return p.TeamId, nil
 }
 
 func (t *ParticipantMonitor) GetKey() string {
@@ -28,7 +43,12 @@ func (t *ParticipantMonitor) GetKey() string {
 }
 
 func (t *ParticipantMonitor) MonitorKey() string {
-	return t.MakeKey(t.GetKey())
+	return t.MakeKey(t.platformKey(t.GetKey())...)
+}
+
+//CacheKey is the groupcache key for the participant
+func (t *ParticipantMonitor) CacheKey() string {
+	return t.cacheKey(t.GetKey())
 }
 
 //KafkaKeyForEvents is used in kafka for identity - For events topic (non-compacted) - tl;dr All data, not fetch time though
@@ -53,7 +73,7 @@ func (t *ParticipantMonitor) KafkaKeyForParticipants(p *df.CachedParticipant) ([
 	}
 
 	bf := new(bytes.Buffer)
-	if err := tplate.Execute(bf, p); err != nil {
+	if err := tplate.Execute(bf, participantKeyData{CachedParticipant: p, Platform: t.GetPlatform(), KeyPrefix: t.kafkaKeyPrefix()}); err != nil {
 		log.WithError(err).Error("Problem executing participant monitor template")
 		return nil, err
 	}
@@ -62,7 +82,7 @@ func (t *ParticipantMonitor) KafkaKeyForParticipants(p *df.CachedParticipant) ([
 
 //KafkaHeaders are used in kafka for info, routing, and debugging
 func (t *ParticipantMonitor) KafkaHeaders(p *df.CachedParticipant) []kafka.Header {
-	ret := make([]kafka.Header, 0)
+	ret := append([]kafka.Header{t.platformHeader()}, t.metaHeaders()...)
 	if p.ParticipantId != 0 {
 		ret = append(ret, kafka.Header{
 			Key:   df.KHeaderKeyParticipantID,
@@ -144,64 +164,58 @@ func (t *ParticipantMonitor) MakeEventsMessages(p *df.CachedParticipant) ([]kafk
 
 //SetUpdateMonitoring turns on monitoring for team.active period
 func (t *ParticipantMonitor) SetUpdateMonitoring(ctx context.Context, duration time.Duration) error {
-	rClient, err := GetRedisClient()
-	if err != nil {
-		return err
-	}
-
-	key := t.MonitorKey()
-
 	data, err := json.Marshal(t)
 	if err != nil {
 		return err
 	}
 
-	if err := rClient.Set(ctx, key, data, duration).Err(); err != nil {
-		return err
-	}
+	// Indexed by expiry so listing only has to look at live monitors
+	return GetStore().Save(ctx, indexKey(t.MonitorName), t.MonitorKey(), data, t.windowTTL(duration, time.Now()))
+}
 
-	// Make lookups for what we have quick - will have to verify where they point exists
-	if err := rClient.SAdd(ctx, t.GetLookupKey(ParticipantMonitorIDSet), key).Err(); err != nil {
+//StopMonitoring removes the participant's monitor and its place in the cadence
+func (t *ParticipantMonitor) StopMonitoring(ctx context.Context) error {
+	st := GetStore()
+	if err := st.Delete(ctx, indexKey(t.MonitorName), t.MonitorKey()); err != nil {
 		return err
 	}
+	return st.Unindex(ctx, dueKey(t.MonitorName), t.MonitorKey())
+}
 
-	return nil
+//Load fills in the monitor from redis - false if it's not there
+func (t *ParticipantMonitor) Load(ctx context.Context) (bool, error) {
+	return loadMonitor(ctx, t.MonitorKey(), t)
 }
 
-//AmMonitoring are we monitoring this id
+//AmMonitoring are we monitoring this id right now - loads the stored monitor's metadata if it exists
 func (t *ParticipantMonitor) AmMonitoring(ctx context.Context) (bool, error) {
 	log := df.Log.WithField("participant.id", t.ParticipantID)
 
-	rClient, err := GetRedisClient()
+	loaded, err := t.Load(ctx)
 	if err != nil {
-		log.WithError(err).Error("Problem getting redis client")
+		log.WithError(err).Error("Problem loading participant monitor")
 		return false, err
 	}
-
-	key := t.MakeKey(t.GetKey())
-	cnt, err := rClient.Exists(ctx, key).Result()
-	if err != nil {
-		log.WithError(err).Error("Problem checking if key exists for client monitoring")
-		return false, err
-	}
-	if cnt == 1 {
-		log.Trace("Am monitoring (direct)")
-		return true, nil
+	if loaded {
+		// A direct monitor's own window wins over the team's
+		inWindow := t.InWindow(time.Now())
+		log.WithField("participant.window", inWindow).Trace("Am monitoring (direct)")
+		return inWindow, nil
 	}
 
 	// Check if we're monitored via team
-	p, err := t.GetParticipant(ctx)
+	teamID, err := participantTeamID(ctx, t)
 	if err != nil {
 		log.WithError(err).Error("Problem getting participant")
 		return false, err
 	}
 
-	if p.TeamId == 0 {
+	if teamID == 0 {
 		log.Trace("No team set - not tracked")
 		return false, nil
 	}
 
-	tm := NewTeamMonitor(p.TeamId)
+	tm := NewTeamMonitor(t.Platform, teamID) // Teams are always on the participant's platform
 
 	amMon, err := tm.AmMonitoring(ctx)
 	if err != nil {
@@ -212,6 +226,7 @@ func (t *ParticipantMonitor) AmMonitoring(ctx context.Context) (bool, error) {
 
 	if amMon {
 		log.Debug("Setting participant as monitored for a bit since the team is monitored")
+		t.InheritMeta(tm.BaseMonitor, tm.MonitorKey())
 		if err := t.SetUpdateMonitoring(ctx, viper.GetDuration("participant.team.active")); err != nil {
 			log.WithError(err).Error("Problem setting participant as monitored")
 			return false, err
@@ -222,61 +237,32 @@ func (t *ParticipantMonitor) AmMonitoring(ctx context.Context) (bool, error) {
 	return amMon, nil
 }
 
-//GetAllParticipants returns a list of all monitored participants
+//GetAllParticipants returns a list of all monitored participants that are in their window
 func GetAllParticipants(ctx context.Context) ([]*ParticipantMonitor, error) {
-	rClient, err := GetRedisClient()
-	if err != nil {
-		return nil, err
-	}
-
-	keys, err := rClient.SMembers(ctx, GetLookupKey(df.MonitorNameParticipant, ParticipantMonitorIDSet)).Result()
-	if err != nil {
-		return nil, err
-	}
-
-	ret := make([]*ParticipantMonitor, 0) // Can't assume len - might have to remove some
-	for _, key := range keys {
-		data, err := rClient.Get(ctx, key).Bytes()
-		if errors.Is(err, redis.Nil) {
-			// Doesn't exist
-			continue
-		}
-		if err != nil {
-			// Something else happened
-			return nil, err
-		}
-
-		pm, err := NewParticipantMonitorFromJSON(data)
-		if err != nil {
-			return nil, err
-		}
-
-		// While it existed earlier, maybe in the future other checks will be here - so do it again
-		if amMon, err := pm.AmMonitoring(ctx); err != nil {
-			return nil, err
-		} else if !amMon {
-			continue // Not monitoring
-		}
+	return getParticipants(ctx, true)
+}
 
-		ret = append(ret, pm)
-	}
+//EachParticipantBatch calls fn with the monitored participants that are in their window - at most monitor.list.batch at a time
+func EachParticipantBatch(ctx context.Context, fn func([]*ParticipantMonitor) error) error {
+	return eachMonitorBatch(ctx, df.MonitorNameParticipant, true, NewParticipantMonitorFromJSON, fn)
+}
 
-	return ret, nil
+//getParticipants lists the stored participant monitors - activeOnly skips ones outside of their window
+func getParticipants(ctx context.Context, activeOnly bool) ([]*ParticipantMonitor, error) {
+	return listMonitors(ctx, df.MonitorNameParticipant, activeOnly, NewParticipantMonitorFromJSON)
 }
 
 //GetParticipant gets the cached participant info
 func (t *ParticipantMonitor) GetParticipant(ctx context.Context) (*df.CachedParticipant, error) {
-	log := df.Log.WithField("participant.id", t.ParticipantID)
+	log := df.Log.WithFields(logrus.Fields{
+		"participant.id":      t.ParticipantID,
+		"donordrive.platform": t.GetPlatform(),
+	})
 	gca := gcache.GlobalCache()
-	teamPGC, err := gca.GetGroupByName(gcache.GroupELParticipants)
-	if err != nil {
-		log.WithError(err).Error("Problem getting gca group by name")
-		return nil, err
-	}
 
 	log.Trace("Kicking off cache get/fill")
-	var data []byte
-	if err := teamPGC.Get(ctx, t.GetKey(), groupcache.AllocatingByteSliceSink(&data)); err != nil {
+	data, err := gca.Fetch(ctx, gcache.GroupELParticipants, t.CacheKey())
+	if err != nil {
 		log.WithError(err).Error("Couldn't get entry from participant's group cache")
 		return nil, err
 	}
@@ -300,15 +286,43 @@ func (t *ParticipantMonitor) GetParticipant(ctx context.Context) (*df.CachedPart
 	return &participant, nil
 }
 
-//WriteParticipantToKafka fetches and writes the updated info from gcache into kafka
-func (t *ParticipantMonitor) WriteParticipantToKafka(ctx context.Context) error {
+//GetParticipantDonations gets the cached donations for the participant
+func (t *ParticipantMonitor) GetParticipantDonations(ctx context.Context) (*df.CachedDonations, error) {
+	log := df.Log.WithFields(logrus.Fields{
+		"participant.id":      t.ParticipantID,
+		"donordrive.platform": t.GetPlatform(),
+	})
+	gca := gcache.GlobalCache()
+
+	log.Trace("Kicking off cache get/fill")
+	data, err := gca.Fetch(ctx, gcache.GroupELDonations, t.CacheKey())
+	if err != nil {
+		log.WithError(err).Error("Couldn't get entry from donations group cache")
+		return nil, err
+	}
+
+	log.Trace("Unmarshalling")
+	// While we could get away without this, let's be sure the schema is right - security :)
+	donations := df.CachedDonations{}
+	if err := json.Unmarshal(data, &donations); err != nil {
+		log.WithError(err).Error("Couldn't unmarshal donations")
+		return nil, err
+	}
+	log = log.WithField("donations.count", donations.Count)
+	donations.RawData = data // Set late
+
+	return &donations, nil
+}
+
+//WriteParticipantToKafka fetches and writes the updated info from gcache into kafka - returns what it wrote
+func (t *ParticipantMonitor) WriteParticipantToKafka(ctx context.Context) (*df.CachedParticipant, error) {
 	log := df.Log.WithField("participants.id", t.ParticipantID)
 
 	log.Trace("Getting participant")
 	participant, err := t.GetParticipant(ctx)
 	if err != nil {
 		log.WithError(err).Error("Problem getting participants from gca")
-		return err
+		return nil, err
 	}
 	log = log.WithFields(logrus.Fields{
 		"participant.name": participant.DisplayName,
@@ -324,14 +338,15 @@ func (t *ParticipantMonitor) WriteParticipantToKafka(ctx context.Context) error
 	kWriteParticipants, err := kdb.W.Get(ctx, kdb.MakeTopicName(df.KTopicParticipants))
 	if err != nil {
 		log.WithError(err).Error("Problem getting kafka writer for participant")
-		return err
+		return nil, err
 	}
 
 	msgs, err := t.MakeParticipantMessages(participant)
 	if err != nil {
 		log.WithError(err).Error("Problem making kafka message(s)")
-		return err
+		return nil, err
 	}
+	tracing.InjectKafka(ctx, msgs)
 	c1, can1 := context.WithTimeout(ctx, time.Second*120)
 	defer can1()
 	if err := kWriteParticipants.WriteMessages(
@@ -339,7 +354,7 @@ func (t *ParticipantMonitor) WriteParticipantToKafka(ctx context.Context) error
 		msgs...,
 	); err != nil {
 		log.WithError(err).Error("Problem writing messages to kafka participants topic")
-		return err
+		return nil, err
 	}
 
 	log.Trace("Recording to events topic")
@@ -347,14 +362,15 @@ func (t *ParticipantMonitor) WriteParticipantToKafka(ctx context.Context) error
 	kWriteEvents, err := kdb.W.Get(ctx, kdb.MakeTopicName(df.KTopicEvents))
 	if err != nil {
 		log.WithError(err).Error("Problem getting kafka writer for events")
-		return err
+		return nil, err
 	}
 
 	msgs, err = t.MakeEventsMessages(participant)
 	if err != nil {
 		log.WithError(err).Error("Problem making kafka message(s)")
-		return err
+		return nil, err
 	}
+	tracing.InjectKafka(ctx, msgs)
 	c2, can2 := context.WithTimeout(ctx, time.Second*120)
 	defer can2()
 	if err := kWriteEvents.WriteMessages(
@@ -362,10 +378,10 @@ func (t *ParticipantMonitor) WriteParticipantToKafka(ctx context.Context) error
 		msgs...,
 	); err != nil {
 		log.WithError(err).Error("Problem writing messages to kafka events topic")
-		return err
+		return nil, err
 	}
 
 	log.Trace("Done with participant update")
 
-	return nil
+	return participant, nil
 }
@@ -0,0 +1,537 @@
+package mondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/ptdave20/donordrive"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testStore swaps in a memory store with a movable clock and fakes participant => team lookups
+func testStore(t *testing.T, teams map[int]int) (*MemoryStore, *time.Time) {
+	t.Helper()
+	if df.Log == nil {
+		l := logrus.New()
+		l.SetLevel(logrus.WarnLevel)
+		df.Log = logrus.NewEntry(l)
+	}
+
+	now := time.Now()
+	st := NewMemoryStore()
+	st.Now = func() time.Time { return now }
+
+	oldStore, oldLookup := GetStore(), participantTeamID
+	SetStore(st)
+	participantTeamID = func(ctx context.Context, p *ParticipantMonitor) (int, error) {
+		return teams[p.ParticipantID], nil
+	}
+	t.Cleanup(func() {
+		SetStore(oldStore)
+		participantTeamID = oldLookup
+	})
+	return st, &now
+}
+
+func monitorTeam(t *testing.T, tm *TeamMonitor) {
+	t.Helper()
+	if err := tm.SetUpdateMonitoring(context.Background()); err != nil {
+		t.Fatalf("monitoring team: %v", err)
+	}
+}
+
+func TestParticipantDirectMonitor(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, nil)
+	participantTeamID = func(ctx context.Context, p *ParticipantMonitor) (int, error) {
+		t.Fatal("looked up the team for a directly monitored participant")
+		return 0, nil
+	}
+
+	if err := NewParticipantMonitor("", 10).SetUpdateMonitoring(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	amMon, err := NewParticipantMonitor("", 10).AmMonitoring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !amMon {
+		t.Error("directly monitored participant isn't monitored")
+	}
+}
+
+func TestParticipantMonitoredViaTeam(t *testing.T) {
+	ctx := context.Background()
+	st, _ := testStore(t, map[int]int{10: 1})
+
+	tm := NewTeamMonitor("", 1)
+	tm.Owner = "someone"
+	tm.Labels = []string{"main"}
+	tm.Notes = "guest team"
+	monitorTeam(t, tm)
+
+	pm := NewParticipantMonitor("", 10)
+	amMon, err := pm.AmMonitoring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !amMon {
+		t.Fatal("participant on a monitored team isn't monitored")
+	}
+
+	// It should now be stored on its own, for a short while, with the team's metadata
+	left, err := st.TTL(ctx, pm.MonitorKey())
+	if err != nil {
+		t.Fatalf("participant wasn't stored: %v", err)
+	}
+	if want := viper.GetDuration("participant.team.active"); left != want {
+		t.Errorf("ttl = %v, want %v", left, want)
+	}
+
+	stored := NewParticipantMonitor("", 10)
+	if loaded, err := stored.Load(ctx); err != nil || !loaded {
+		t.Fatalf("loading participant: %v %v", loaded, err)
+	}
+	if stored.Owner != "someone" || !stored.HasLabel("main") || stored.Notes != "guest team" {
+		t.Errorf("metadata not inherited from team: %+v", stored.MonitorMeta)
+	}
+
+	all, err := GetAllParticipants(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ParticipantID != 10 {
+		t.Errorf("GetAllParticipants = %v, want participant 10", all)
+	}
+}
+
+func TestParticipantTeamNotMonitored(t *testing.T) {
+	ctx := context.Background()
+	st, _ := testStore(t, map[int]int{10: 1})
+	monitorTeam(t, NewTeamMonitor("", 2)) // Some other team
+
+	pm := NewParticipantMonitor("", 10)
+	amMon, err := pm.AmMonitoring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amMon {
+		t.Error("participant on an unmonitored team is monitored")
+	}
+	if _, err := st.Get(ctx, pm.MonitorKey()); !errors.Is(err, ErrNotStored) {
+		t.Errorf("participant was stored: %v", err)
+	}
+}
+
+func TestParticipantWithoutTeam(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, nil)
+	monitorTeam(t, NewTeamMonitor("", 0))
+
+	amMon, err := NewParticipantMonitor("", 10).AmMonitoring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amMon {
+		t.Error("participant without a team is monitored")
+	}
+}
+
+func TestParticipantTeamLookupError(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, nil)
+	errLookup := errors.New("donordrive is down")
+	participantTeamID = func(ctx context.Context, p *ParticipantMonitor) (int, error) {
+		return 0, errLookup
+	}
+
+	if _, err := NewParticipantMonitor("", 10).AmMonitoring(ctx); !errors.Is(err, errLookup) {
+		t.Errorf("err = %v, want %v", err, errLookup)
+	}
+}
+
+func TestParticipantTeamPendingWindow(t *testing.T) {
+	ctx := context.Background()
+	st, _ := testStore(t, map[int]int{10: 1})
+
+	start := time.Now().Add(time.Hour)
+	tm := NewTeamMonitor("", 1)
+	tm.Start = &start
+	monitorTeam(t, tm)
+
+	pm := NewParticipantMonitor("", 10)
+	amMon, err := pm.AmMonitoring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amMon {
+		t.Error("participant is monitored before the team's window starts")
+	}
+	if _, err := st.Get(ctx, pm.MonitorKey()); !errors.Is(err, ErrNotStored) {
+		t.Errorf("participant was stored: %v", err)
+	}
+}
+
+func TestParticipantOwnWindowWins(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, map[int]int{10: 1})
+	monitorTeam(t, NewTeamMonitor("", 1))
+
+	start := time.Now().Add(time.Hour)
+	pm := NewParticipantMonitor("", 10)
+	pm.Start = &start
+	if err := pm.SetUpdateMonitoring(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	amMon, err := NewParticipantMonitor("", 10).AmMonitoring(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amMon {
+		t.Error("participant is monitored before its own window starts even though its team is monitored")
+	}
+}
+
+func TestParticipantViaTeamExpires(t *testing.T) {
+	ctx := context.Background()
+	st, now := testStore(t, map[int]int{10: 1})
+	monitorTeam(t, NewTeamMonitor("", 1))
+
+	pm := NewParticipantMonitor("", 10)
+	if amMon, err := pm.AmMonitoring(ctx); err != nil || !amMon {
+		t.Fatalf("AmMonitoring = %v, %v", amMon, err)
+	}
+
+	// Our own key is gone but the team is still monitored - picked back up
+	*now = now.Add(viper.GetDuration("participant.team.active") + time.Minute)
+	if _, err := st.Get(ctx, pm.MonitorKey()); !errors.Is(err, ErrNotStored) {
+		t.Fatalf("participant key didn't expire: %v", err)
+	}
+	if amMon, err := NewParticipantMonitor("", 10).AmMonitoring(ctx); err != nil || !amMon {
+		t.Fatalf("AmMonitoring after participant expiry = %v, %v", amMon, err)
+	}
+
+	// Now the team's gone too
+	*now = now.Add(viper.GetDuration("team.active"))
+	if amMon, err := NewParticipantMonitor("", 10).AmMonitoring(ctx); err != nil || amMon {
+		t.Errorf("AmMonitoring after team expiry = %v, %v", amMon, err)
+	}
+	if all, err := GetAllParticipants(ctx); err != nil || len(all) != 0 {
+		t.Errorf("GetAllParticipants = %v, %v - want none", all, err)
+	}
+}
+
+func TestParticipantTeamOnSamePlatform(t *testing.T) {
+	ctx := context.Background()
+	testStore(t, map[int]int{10: 1})
+	monitorTeam(t, NewTeamMonitor("", 1)) // Default platform
+
+	pm := NewParticipantMonitor("other", 10)
+	if amMon, err := pm.AmMonitoring(ctx); err != nil || amMon {
+		t.Fatalf("participant monitored via a team on another platform: %v, %v", amMon, err)
+	}
+
+	monitorTeam(t, NewTeamMonitor("other", 1))
+	if amMon, err := NewParticipantMonitor("other", 10).AmMonitoring(ctx); err != nil || !amMon {
+		t.Errorf("participant not monitored via its team: %v, %v", amMon, err)
+	}
+}
+
+func TestTeamExtendUpdateMonitoring(t *testing.T) {
+	ctx := context.Background()
+	st, _ := testStore(t, nil)
+
+	parent := NewEventMonitor("", 5)
+	parent.Labels = []string{"event"}
+
+	// New - inherits the parent's metadata
+	tm := NewTeamMonitor("", 1)
+	if err := tm.ExtendUpdateMonitoring(ctx, time.Hour, parent); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := st.TTL(ctx, tm.MonitorKey()); err != nil || left != time.Hour {
+		t.Fatalf("TTL = %v, %v - want 1h", left, err)
+	}
+	stored := NewTeamMonitor("", 1)
+	if loaded, err := stored.Load(ctx); err != nil || !loaded || !stored.HasLabel("event") {
+		t.Errorf("new team monitor didn't inherit labels: %v %v %+v", loaded, err, stored.MonitorMeta)
+	}
+
+	// Never shortened
+	if err := NewTeamMonitor("", 1).ExtendUpdateMonitoring(ctx, time.Minute, parent); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := st.TTL(ctx, tm.MonitorKey()); left != time.Hour {
+		t.Errorf("TTL = %v after a shorter extend, want 1h", left)
+	}
+
+	// Lengthened
+	if err := NewTeamMonitor("", 1).ExtendUpdateMonitoring(ctx, time.Hour*2, parent); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := st.TTL(ctx, tm.MonitorKey()); left != time.Hour*2 {
+		t.Errorf("TTL = %v after a longer extend, want 2h", left)
+	}
+
+	all, err := GetAllTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].TeamID != 1 {
+		t.Errorf("GetAllTeams = %v, want team 1", all)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	st, now := testStore(t, nil)
+
+	if err := st.Save(ctx, "idx", "forever", []byte("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if left, err := st.TTL(ctx, "forever"); err != nil || left != NoExpiry {
+		t.Errorf("TTL = %v, %v - want NoExpiry", left, err)
+	}
+
+	if err := st.Save(ctx, "idx", "short", []byte("b"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Minute)
+	if _, err := st.Get(ctx, "short"); !errors.Is(err, ErrNotStored) {
+		t.Errorf("Get after expiry = %v, want ErrNotStored", err)
+	}
+	if err := st.Expire(ctx, "idx", "short", time.Hour); !errors.Is(err, ErrNotStored) {
+		t.Errorf("Expire of a missing key = %v, want ErrNotStored", err)
+	}
+	if data, err := st.Get(ctx, "forever"); err != nil || string(data) != "a" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+
+	if keys, _, err := st.Scan(ctx, "idx", IndexCursor{}, 10); err != nil || len(keys) != 1 || keys[0] != "forever" {
+		t.Errorf("Scan = %v, %v - want only forever", keys, err)
+	}
+	if pruned, err := st.Prune(ctx, "idx"); err != nil || len(pruned) != 1 || pruned[0] != "short" {
+		t.Errorf("Prune = %v, %v - want only short", pruned, err)
+	}
+}
+
+func TestListingBatches(t *testing.T) {
+	ctx := context.Background()
+	st, now := testStore(t, nil)
+	old := viper.GetInt("monitor.list.batch")
+	viper.Set("monitor.list.batch", 3)
+	t.Cleanup(func() { viper.Set("monitor.list.batch", old) })
+
+	for id := 1; id <= 7; id++ {
+		tm := NewTeamMonitor("", id)
+		if err := tm.setUpdateMonitoring(ctx, time.Duration(id)*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		// Pretend they've all been scheduled
+		st.lock.Lock()
+		st.index(dueKey(df.MonitorNameTeam))[tm.MonitorKey()] = time.Time{}
+		st.lock.Unlock()
+	}
+
+	sizes := make([]int, 0)
+	seen := make(map[int]bool)
+	err := EachTeamBatch(ctx, func(batch []*TeamMonitor) error {
+		sizes = append(sizes, len(batch))
+		for _, tm := range batch {
+			seen[tm.TeamID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 7 || len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("batches = %v with %d teams, want [3 3 1] with 7", sizes, len(seen))
+	}
+
+	// Two expire, and they're pruned from the index and the cadence
+	*now = now.Add(time.Hour*2 + time.Minute)
+	all, err := GetAllTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("GetAllTeams = %d teams, want 5", len(all))
+	}
+	if all[0].TeamID != 3 {
+		t.Errorf("GetAllTeams starts with team %d, want team 3", all[0].TeamID)
+	}
+	if keys, _, _ := st.Scan(ctx, indexKey(df.MonitorNameTeam), IndexCursor{}, -1); len(keys) != 5 {
+		t.Errorf("index has %d keys after pruning, want 5", len(keys))
+	}
+	due, _, _ := st.Scan(ctx, dueKey(df.MonitorNameTeam), IndexCursor{}, -1)
+	for _, key := range due {
+		if key == NewTeamMonitor("", 1).MonitorKey() || key == NewTeamMonitor("", 2).MonitorKey() {
+			t.Errorf("expired monitor %s still has a cadence", key)
+		}
+	}
+	if len(due) != 5 {
+		t.Errorf("cadence has %d keys after pruning, want 5", len(due))
+	}
+}
+
+func TestListingExpiryMidWalk(t *testing.T) {
+	ctx := context.Background()
+	_, now := testStore(t, nil)
+	old := viper.GetInt("monitor.list.batch")
+	viper.Set("monitor.list.batch", 3)
+	t.Cleanup(func() { viper.Set("monitor.list.batch", old) })
+
+	for id := 1; id <= 8; id++ {
+		if err := NewTeamMonitor("", id).setUpdateMonitoring(ctx, time.Duration(id)*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Never expires - listed last
+	if err := NewTeamMonitor("", 9).setUpdateMonitoring(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make([]int, 0)
+	err := EachTeamBatch(ctx, func(batch []*TeamMonitor) error {
+		for _, tm := range batch {
+			seen = append(seen, tm.TeamID)
+		}
+		// The first two expire once the first batch is done - the rest can't shift into the spots they leave
+		*now = now.Add(time.Minute * 5)
+		if len(seen) == 3 {
+			*now = now.Add(time.Hour * 2)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("listed %v, want %v", seen, want)
+	}
+}
+
+func TestScanCursorTies(t *testing.T) {
+	ctx := context.Background()
+	st, _ := testStore(t, nil)
+	for _, key := range []string{"d", "b", "e", "a", "c"} {
+		if err := st.Save(ctx, "idx", key, []byte(key), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := make([]string, 0)
+	cursor := IndexCursor{}
+	for pages := 0; pages < 10; pages++ {
+		keys, next, err := st.Scan(ctx, "idx", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) == 0 {
+			break
+		}
+		got = append(got, keys...)
+		cursor = next
+		// Dropping keys already listed doesn't move the cursor
+		if err := st.Delete(ctx, "idx", keys[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func roster(ids ...int) *df.CachedParticipants {
+	ret := &df.CachedParticipants{}
+	for _, id := range ids {
+		ret.Participants = append(ret.Participants, donordrive.Participant{ParticipantId: id, DisplayName: fmt.Sprintf("p%d", id)})
+	}
+	return ret
+}
+
+func TestTeamRosterChanges(t *testing.T) {
+	ctx := context.Background()
+	st, _ := testStore(t, nil)
+
+	tm := NewTeamMonitor("", 1)
+	tm.Labels = []string{"main"}
+	monitorTeam(t, tm)
+
+	// First roster is just remembered
+	if changes, err := tm.RosterChanges(ctx, roster(10, 11, 12)); err != nil || len(changes) != 0 {
+		t.Fatalf("first RosterChanges = %v, %v - want none", changes, err)
+	}
+	if err := tm.SaveRoster(ctx, roster(10, 11, 12)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 11 was added directly, 12 via the team
+	if err := NewParticipantMonitor("", 11).SetUpdateMonitoring(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	p12 := NewParticipantMonitor("", 12)
+	p12.InheritMeta(tm.BaseMonitor, tm.MonitorKey())
+	if err := p12.SetUpdateMonitoring(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := tm.RosterChanges(ctx, roster(10, 13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		id         int
+		changeType string
+	}{
+		{11, df.ChangeTypeParticipantLeft},
+		{12, df.ChangeTypeParticipantLeft},
+		{13, df.ChangeTypeParticipantJoined},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("RosterChanges = %+v, want %v", changes, want)
+	}
+	for i, w := range want {
+		if changes[i].ParticipantID != w.id || changes[i].Type != w.changeType || changes[i].TeamID != 1 {
+			t.Errorf("change %d = %+v, want %v", i, changes[i], w)
+		}
+	}
+	if changes[0].DisplayName != "p11" {
+		t.Errorf("left participant's name = %q, want p11", changes[0].DisplayName)
+	}
+
+	started, stopped, err := tm.ApplyRosterChanges(ctx, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started != 1 || stopped != 1 {
+		t.Errorf("started %d, stopped %d - want 1 and 1", started, stopped)
+	}
+
+	// Direct monitor is kept, the team's one is gone, and the new one has the team's labels
+	if _, err := st.Get(ctx, NewParticipantMonitor("", 11).MonitorKey()); err != nil {
+		t.Errorf("directly monitored participant was stopped: %v", err)
+	}
+	if _, err := st.Get(ctx, p12.MonitorKey()); !errors.Is(err, ErrNotStored) {
+		t.Errorf("participant that left is still monitored: %v", err)
+	}
+	p13 := NewParticipantMonitor("", 13)
+	if loaded, err := p13.Load(ctx); err != nil || !loaded || !p13.HasLabel("main") {
+		t.Errorf("participant that joined: loaded %v, err %v, meta %+v", loaded, err, p13.MonitorMeta)
+	}
+
+	if err := tm.SaveRoster(ctx, roster(10, 13)); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err := tm.RosterChanges(ctx, roster(13, 10)); err != nil || len(changes) != 0 {
+		t.Errorf("RosterChanges with the same roster = %v, %v - want none", changes, err)
+	}
+}
@@ -0,0 +1,206 @@
+package mondb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/kdb"
+	"github.com/fragforce/fragevents/lib/tracing"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"sort"
+	"time"
+)
+
+func init() {
+	viper.SetDefault("team.roster.ttl", time.Hour*24*7) // How long to remember a team's roster after it was last seen
+}
+
+//rosterKey is where the team's last seen roster is kept
+func (t *TeamMonitor) rosterKey() string {
+	return t.MakeKey(append(t.platformKey(t.GetKey()), "roster")...)
+}
+
+//makeRoster maps participant id => display name for the team's current participants
+func makeRoster(participants *df.CachedParticipants) map[int]string {
+	ret := make(map[int]string, len(participants.Participants))
+	for _, p := range participants.Participants {
+		if p.ParticipantId == 0 {
+			continue
+		}
+		ret[p.ParticipantId] = p.DisplayName
+	}
+	return ret
+}
+
+//RosterChanges compares the team's current participants with the last saved roster - nothing if there isn't one yet,
+// otherwise everyone on a new team would have 'joined'
+func (t *TeamMonitor) RosterChanges(ctx context.Context, participants *df.CachedParticipants) ([]df.RosterChange, error) {
+	data, err := GetStore().Get(ctx, t.rosterKey())
+	if errors.Is(err, ErrNotStored) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	last := make(map[int]string)
+	if err := json.Unmarshal(data, &last); err != nil {
+		return nil, err
+	}
+	cur := makeRoster(participants)
+
+	now := time.Now().UTC()
+	ret := make([]df.RosterChange, 0)
+	for id, name := range cur {
+		if _, ok := last[id]; !ok {
+			ret = append(ret, t.rosterChange(df.ChangeTypeParticipantJoined, id, name, now))
+		}
+	}
+	for id, name := range last {
+		if _, ok := cur[id]; !ok {
+			ret = append(ret, t.rosterChange(df.ChangeTypeParticipantLeft, id, name, now))
+		}
+	}
+	// Stable order for kafka
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ParticipantID < ret[j].ParticipantID
+	})
+	return ret, nil
+}
+
+func (t *TeamMonitor) rosterChange(changeType string, participantID int, name string, at time.Time) df.RosterChange {
+	return df.RosterChange{
+		Type:          changeType,
+		Platform:      t.GetPlatform(),
+		TeamID:        t.TeamID,
+		ParticipantID: participantID,
+		DisplayName:   name,
+		At:            at,
+	}
+}
+
+//SaveRoster stores the team's current participants for the next RosterChanges
+func (t *TeamMonitor) SaveRoster(ctx context.Context, participants *df.CachedParticipants) error {
+	data, err := json.Marshal(makeRoster(participants))
+	if err != nil {
+		return err
+	}
+	return GetStore().Save(ctx, "", t.rosterKey(), data, viper.GetDuration("team.roster.ttl"))
+}
+
+//RosterKafkaHeaders are used in kafka for info, routing, and debugging
+func (t *TeamMonitor) RosterKafkaHeaders(change *df.RosterChange) []kafka.Header {
+	ret := append([]kafka.Header{t.platformHeader()}, t.metaHeaders()...)
+	ret = append(ret,
+		kafka.Header{
+			Key:   df.KHeaderKeyChangeType,
+			Value: []byte(change.Type),
+		},
+		kafka.Header{
+			Key:   df.KHeaderKeyTeamID,
+			Value: []byte(fmt.Sprintf("%d", change.TeamID)),
+		},
+		kafka.Header{
+			Key:   df.KHeaderKeyParticipantID,
+			Value: []byte(fmt.Sprintf("%d", change.ParticipantID)),
+		},
+	)
+	if change.DisplayName != "" {
+		ret = append(ret, kafka.Header{
+			Key:   df.KHeaderKeyDisplayName,
+			Value: []byte(change.DisplayName),
+		})
+	}
+	return ret
+}
+
+//MakeRosterMessages creates the kafka message(s) for the roster changes - events topic, keyed by team so they stay in order
+func (t *TeamMonitor) MakeRosterMessages(changes []df.RosterChange) ([]kafka.Message, error) {
+	key := []byte(fmt.Sprintf("%s-%d", t.GetPlatform(), t.TeamID))
+	ret := make([]kafka.Message, 0, len(changes))
+	for i := range changes {
+		value, err := json.Marshal(changes[i])
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, kafka.Message{
+			Key:     key,
+			Value:   value,
+			Headers: t.RosterKafkaHeaders(&changes[i]),
+		})
+	}
+	return ret, nil
+}
+
+//WriteRosterChangesToKafka publishes the roster changes to the events topic
+func (t *TeamMonitor) WriteRosterChangesToKafka(ctx context.Context, changes []df.RosterChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	log := df.Log.WithFields(logrus.Fields{
+		"team.id":             t.TeamID,
+		"donordrive.platform": t.GetPlatform(),
+		"changes.count":       len(changes),
+		"topic.events":        kdb.MakeTopicName(df.KTopicEvents),
+	})
+
+	kWrite, err := kdb.W.Get(ctx, kdb.MakeTopicName(df.KTopicEvents))
+	if err != nil {
+		log.WithError(err).Error("Problem getting kafka writer for events")
+		return err
+	}
+
+	msgs, err := t.MakeRosterMessages(changes)
+	if err != nil {
+		log.WithError(err).Error("Problem making kafka message(s)")
+		return err
+	}
+	tracing.InjectKafka(ctx, msgs)
+	c1, can1 := context.WithTimeout(ctx, time.Second*120)
+	defer can1()
+	if err := kWrite.WriteMessages(
+		c1,
+		msgs...,
+	); err != nil {
+		log.WithError(err).Error("Problem writing roster changes to kafka events topic")
+		return err
+	}
+	return nil
+}
+
+//ApplyRosterChanges starts monitoring participants that joined and stops it for ones that left - only ones being
+// monitored because of this team are stopped, directly registered ones are left alone
+func (t *TeamMonitor) ApplyRosterChanges(ctx context.Context, changes []df.RosterChange) (started int, stopped int, err error) {
+	for _, change := range changes {
+		pm := NewParticipantMonitor(t.Platform, change.ParticipantID) // Same platform as the team
+		loaded, err := pm.Load(ctx)
+		if err != nil {
+			return started, stopped, err
+		}
+
+		switch change.Type {
+		case df.ChangeTypeParticipantJoined:
+			if loaded {
+				continue // Already monitored one way or another
+			}
+			pm.InheritMeta(t.BaseMonitor, t.MonitorKey())
+			if err := pm.SetUpdateMonitoring(ctx, viper.GetDuration("participant.team.active")); err != nil {
+				return started, stopped, err
+			}
+			started++
+		case df.ChangeTypeParticipantLeft:
+			if !loaded || pm.Client != t.MonitorKey() {
+				continue
+			}
+			if err := pm.StopMonitoring(ctx); err != nil {
+				return started, stopped, err
+			}
+			stopped++
+		}
+	}
+	return started, stopped, nil
+}
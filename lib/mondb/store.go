@@ -0,0 +1,690 @@
+package mondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	NoExpiry time.Duration = -1 // TTL of a key that never expires
+)
+
+var (
+	ErrNotStored              = errors.New("monitor key not stored")
+	store        MonitorStore = &RedisStore{}
+	storeLock    sync.RWMutex
+)
+
+// MonitorStore is where monitor state lives - keys expire like redis keys do, and each key is in an index ordered by
+// when it expires so listing doesn't have to touch every key
+type MonitorStore interface {
+	//Save stores data at key and indexes it by when it expires - a ttl of 0 or less keeps it forever, an empty index
+	// skips indexing
+	Save(ctx context.Context, index string, key string, data []byte, ttl time.Duration) error
+	//Get returns the data at key - ErrNotStored if it's missing or expired
+	Get(ctx context.Context, key string) ([]byte, error)
+	//GetMany returns the data for each key, in order - nil for ones that are missing or expired
+	GetMany(ctx context.Context, keys []string) ([][]byte, error)
+	//TTL is how long key has left - NoExpiry if it doesn't expire, ErrNotStored if it's missing or expired
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	//Expire changes how long key has left and moves it in the index - ErrNotStored if it's missing or expired
+	Expire(ctx context.Context, index string, key string, ttl time.Duration) error
+	//Scan lists up to count unexpired keys in the index that come after the cursor - soonest to expire first, then by
+	// key. Returns the cursor to pass to get the next page, which is empty once there are no more. A count of 0 or
+	// less lists them all.
+	Scan(ctx context.Context, index string, cursor IndexCursor, count int64) ([]string, IndexCursor, error)
+	//Prune drops expired keys from the index and returns them
+	Prune(ctx context.Context, index string) ([]string, error)
+	//Unindex drops the keys from the index - for ones that were removed some other way
+	Unindex(ctx context.Context, index string, keys ...string) error
+	//Delete removes key and drops it from the index - missing keys are fine
+	Delete(ctx context.Context, index string, key string) error
+	//AddPoint adds data to the series at key with the score (unix ms) - replace drops any other point with the same
+	// score first. Points scored before keepFrom are dropped and the series expires after ttl
+	AddPoint(ctx context.Context, key string, score int64, data []byte, replace bool, keepFrom int64, ttl time.Duration) error
+	//RangePoints returns the series' points scored from min to max inclusive - lowest score first
+	RangePoints(ctx context.Context, key string, min int64, max int64) ([][]byte, error)
+	//SetField sets the field in the hash at key and expires the hash after ttl
+	SetField(ctx context.Context, key string, field string, data []byte, ttl time.Duration) error
+	//SetFieldIfMissing is SetField, unless the field is already set - true if it set it. The hash's expiry is pushed
+	// out either way
+	SetFieldIfMissing(ctx context.Context, key string, field string, data []byte, ttl time.Duration) (bool, error)
+	//GetFields returns all of the fields in the hash at key - empty if it's missing or expired
+	GetFields(ctx context.Context, key string) (map[string][]byte, error)
+	//DeleteFields removes the fields from the hash at key - missing ones are fine
+	DeleteFields(ctx context.Context, key string, fields ...string) error
+}
+
+// IndexCursor is where a Scan of an index left off - unlike an offset it doesn't shift when keys before it expire.
+// The zero value starts at the beginning.
+type IndexCursor struct {
+	Score float64 // Index score of the last key listed - see indexScore
+	Key   string
+}
+
+//after checks if the index entry comes after the cursor
+func (c IndexCursor) after(score float64, key string) bool {
+	if c.Key == "" {
+		return true
+	}
+	return score > c.Score || (score == c.Score && key > c.Key)
+}
+
+//GetStore gets the store monitors are kept in - redis unless SetStore was called
+func GetStore() MonitorStore {
+	storeLock.RLock()
+	defer storeLock.RUnlock()
+	return store
+}
+
+//SetStore changes the store monitors are kept in - for tests and tools that don't have redis
+func SetStore(s MonitorStore) {
+	storeLock.Lock()
+	defer storeLock.Unlock()
+	store = s
+}
+
+// RedisStore keeps monitors in the monitoring redis pool
+type RedisStore struct{}
+
+//indexScore is the index score for a key expiring after ttl - unix ms, or +inf for never
+func indexScore(now time.Time, ttl time.Duration) float64 {
+	if ttl <= 0 {
+		return math.Inf(1)
+	}
+	return float64(now.Add(ttl).UnixMilli())
+}
+
+//expiryScore is the index score for a key expiring at expires - zero is never
+func expiryScore(expires time.Time) float64 {
+	if expires.IsZero() {
+		return math.Inf(1)
+	}
+	return float64(expires.UnixMilli())
+}
+
+//formatScore formats an index score for a redis range
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "+inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+func (s *RedisStore) Save(ctx context.Context, index string, key string, data []byte, ttl time.Duration) error {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	score := indexScore(time.Now(), ttl)
+	if ttl < 0 {
+		ttl = 0 // -1 is KeepTTL to go-redis
+	}
+	pipe := rClient.TxPipeline()
+	pipe.Set(ctx, key, data, ttl)
+	if index != "" {
+		pipe.ZAdd(ctx, index, &redis.Z{Score: score, Member: key})
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	data, err := rClient.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotStored
+	}
+	return data, err
+}
+
+func (s *RedisStore) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+	ret := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return ret, nil
+	}
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	vals, err := rClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range vals {
+		if str, ok := val.(string); ok {
+			ret[i] = []byte(str)
+		}
+	}
+	return ret, nil
+}
+
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return 0, err
+	}
+	left, err := rClient.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	switch left {
+	case -2: // No key
+		return 0, ErrNotStored
+	case -1:
+		return NoExpiry, nil
+	}
+	return left, nil
+}
+
+func (s *RedisStore) Expire(ctx context.Context, index string, key string, ttl time.Duration) error {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	ok, err := rClient.PExpire(ctx, key, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotStored
+	}
+	return rClient.ZAdd(ctx, index, &redis.Z{Score: indexScore(time.Now(), ttl), Member: key}).Err()
+}
+
+func (s *RedisStore) Scan(ctx context.Context, index string, cursor IndexCursor, count int64) ([]string, IndexCursor, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, IndexCursor{}, err
+	}
+
+	now := float64(time.Now().UnixMilli())
+	min := fmt.Sprintf("(%d", int64(now))
+	if cursor.Key != "" && cursor.Score > now {
+		min = formatScore(cursor.Score) // Inclusive - ties up to the cursor's key are skipped below
+	}
+	if count <= 0 {
+		count = -1
+	}
+
+	ret := make([]string, 0)
+	next := IndexCursor{}
+	for offset := int64(0); ; offset += count {
+		zs, err := rClient.ZRangeByScoreWithScores(ctx, index, &redis.ZRangeBy{
+			Min:    min,
+			Max:    "+inf",
+			Offset: offset,
+			Count:  count,
+		}).Result()
+		if err != nil {
+			return nil, IndexCursor{}, err
+		}
+		for _, z := range zs {
+			key, _ := z.Member.(string)
+			if !cursor.after(z.Score, key) {
+				continue
+			}
+			ret = append(ret, key)
+			next = IndexCursor{Score: z.Score, Key: key}
+		}
+		// A page that was all ties before the cursor means there could be more after them
+		if len(ret) > 0 || count < 0 || int64(len(zs)) < count {
+			return ret, next, nil
+		}
+	}
+}
+
+func (s *RedisStore) Prune(ctx context.Context, index string) ([]string, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	max := fmt.Sprintf("%d", time.Now().UnixMilli())
+	expired, err := rClient.ZRangeByScore(ctx, index, &redis.ZRangeBy{Min: "-inf", Max: max}).Result()
+	if err != nil || len(expired) == 0 {
+		return expired, err
+	}
+	// Only what we listed - anything saved since has a later score
+	if err := rClient.ZRemRangeByScore(ctx, index, "-inf", max).Err(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+func (s *RedisStore) Unindex(ctx context.Context, index string, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	members := make([]interface{}, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	return rClient.ZRem(ctx, index, members...).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, index string, key string) error {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	pipe := rClient.TxPipeline()
+	pipe.Del(ctx, key)
+	if index != "" {
+		pipe.ZRem(ctx, index, key)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) AddPoint(ctx context.Context, key string, score int64, data []byte, replace bool, keepFrom int64, ttl time.Duration) error {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	pipe := rClient.TxPipeline()
+	if replace {
+		pipe.ZRemRangeByScore(ctx, key, fmt.Sprintf("%d", score), fmt.Sprintf("%d", score))
+	}
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(score), Member: data})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", keepFrom))
+	pipe.PExpire(ctx, key, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) RangePoints(ctx context.Context, key string, min int64, max int64) ([][]byte, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	members, err := rClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", min),
+		Max: fmt.Sprintf("%d", max),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([][]byte, len(members))
+	for i, member := range members {
+		ret[i] = []byte(member)
+	}
+	return ret, nil
+}
+
+func (s *RedisStore) SetField(ctx context.Context, key string, field string, data []byte, ttl time.Duration) error {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	pipe := rClient.TxPipeline()
+	pipe.HSet(ctx, key, field, data)
+	pipe.PExpire(ctx, key, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *RedisStore) SetFieldIfMissing(ctx context.Context, key string, field string, data []byte, ttl time.Duration) (bool, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return false, err
+	}
+	pipe := rClient.TxPipeline()
+	set := pipe.HSetNX(ctx, key, field, data)
+	pipe.PExpire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return set.Val(), nil
+}
+
+func (s *RedisStore) GetFields(ctx context.Context, key string) (map[string][]byte, error) {
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return nil, err
+	}
+	fields, err := rClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string][]byte, len(fields))
+	for field, data := range fields {
+		ret[field] = []byte(data)
+	}
+	return ret, nil
+}
+
+func (s *RedisStore) DeleteFields(ctx context.Context, key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	rClient, err := GetRedisClient()
+	if err != nil {
+		return err
+	}
+	return rClient.HDel(ctx, key, fields...).Err()
+}
+
+type memEntry struct {
+	data    []byte
+	expires time.Time // Zero is never
+}
+
+type memSeries struct {
+	points  map[string]int64 // data => score, like a sorted set
+	expires time.Time
+}
+
+type memHash struct {
+	fields  map[string][]byte
+	expires time.Time
+}
+
+// MemoryStore keeps monitors in process - nothing is shared between instances, so it's only good for tests and tools
+type MemoryStore struct {
+	Now func() time.Time // Clock used for expiry - time.Now by default
+
+	lock    sync.Mutex
+	keys    map[string]*memEntry
+	indexes map[string]map[string]time.Time // index => key => expiry, zero is never
+	series  map[string]*memSeries
+	hashes  map[string]*memHash
+}
+
+//NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Now:     time.Now,
+		keys:    make(map[string]*memEntry),
+		indexes: make(map[string]map[string]time.Time),
+		series:  make(map[string]*memSeries),
+		hashes:  make(map[string]*memHash),
+	}
+}
+
+//entry gets the live entry for key, dropping it if it's expired - must hold the lock
+func (s *MemoryStore) entry(key string) *memEntry {
+	e, ok := s.keys[key]
+	if !ok {
+		return nil
+	}
+	if !e.expires.IsZero() && !s.Now().Before(e.expires) {
+		delete(s.keys, key)
+		return nil
+	}
+	return e
+}
+
+//hash gets the live hash at key, dropping it if it's expired - makes an empty one if create is set. Must hold the lock
+func (s *MemoryStore) hash(key string, create bool) *memHash {
+	h, ok := s.hashes[key]
+	if ok && !s.Now().Before(h.expires) {
+		delete(s.hashes, key)
+		ok = false
+	}
+	if !ok && create {
+		h = &memHash{fields: make(map[string][]byte)}
+		s.hashes[key] = h
+	}
+	return h
+}
+
+//index gets or makes the index - must hold the lock
+func (s *MemoryStore) index(index string) map[string]time.Time {
+	idx, ok := s.indexes[index]
+	if !ok {
+		idx = make(map[string]time.Time)
+		s.indexes[index] = idx
+	}
+	return idx
+}
+
+func (s *MemoryStore) Save(ctx context.Context, index string, key string, data []byte, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := &memEntry{data: append([]byte(nil), data...)}
+	if ttl > 0 {
+		e.expires = s.Now().Add(ttl)
+	}
+	s.keys[key] = e
+	if index != "" {
+		s.index(index)[key] = e.expires
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		return nil, ErrNotStored
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+func (s *MemoryStore) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([][]byte, len(keys))
+	for i, key := range keys {
+		if e := s.entry(key); e != nil {
+			ret[i] = append([]byte(nil), e.data...)
+		}
+	}
+	return ret, nil
+}
+
+func (s *MemoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		return 0, ErrNotStored
+	}
+	if e.expires.IsZero() {
+		return NoExpiry, nil
+	}
+	return e.expires.Sub(s.Now()), nil
+}
+
+func (s *MemoryStore) Expire(ctx context.Context, index string, key string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := s.entry(key)
+	if e == nil {
+		return ErrNotStored
+	}
+	if ttl <= 0 {
+		// Same as redis - expiring in the past deletes it
+		delete(s.keys, key)
+		return nil
+	}
+	e.expires = s.Now().Add(ttl)
+	s.index(index)[key] = e.expires
+	return nil
+}
+
+func (s *MemoryStore) Scan(ctx context.Context, index string, cursor IndexCursor, count int64) ([]string, IndexCursor, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.Now()
+	idx := s.indexes[index]
+	keys := make([]string, 0, len(idx))
+	for key, expires := range idx {
+		if (expires.IsZero() || expires.After(now)) && cursor.after(expiryScore(expires), key) {
+			keys = append(keys, key)
+		}
+	}
+	// Same order as the redis sorted set - by score, never last, then by key
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := expiryScore(idx[keys[i]]), expiryScore(idx[keys[j]])
+		if si == sj {
+			return keys[i] < keys[j]
+		}
+		return si < sj
+	})
+
+	if count > 0 && count < int64(len(keys)) {
+		keys = keys[:count]
+	}
+	if len(keys) == 0 {
+		return keys, IndexCursor{}, nil
+	}
+	last := keys[len(keys)-1]
+	return keys, IndexCursor{Score: expiryScore(idx[last]), Key: last}, nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, index string) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.Now()
+	ret := make([]string, 0)
+	for key, expires := range s.indexes[index] {
+		if !expires.IsZero() && !expires.After(now) {
+			delete(s.indexes[index], key)
+			ret = append(ret, key)
+		}
+	}
+	return ret, nil
+}
+
+func (s *MemoryStore) Unindex(ctx context.Context, index string, keys ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, key := range keys {
+		delete(s.indexes[index], key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, index string, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.keys, key)
+	delete(s.indexes[index], key)
+	return nil
+}
+
+func (s *MemoryStore) AddPoint(ctx context.Context, key string, score int64, data []byte, replace bool, keepFrom int64, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.Now()
+	ser, ok := s.series[key]
+	if !ok || !now.Before(ser.expires) {
+		ser = &memSeries{points: make(map[string]int64)}
+		s.series[key] = ser
+	}
+	for member, mScore := range ser.points {
+		if mScore < keepFrom || (replace && mScore == score) {
+			delete(ser.points, member)
+		}
+	}
+	if score >= keepFrom {
+		ser.points[string(data)] = score
+	}
+	ser.expires = now.Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) RangePoints(ctx context.Context, key string, min int64, max int64) ([][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ser, ok := s.series[key]
+	if !ok || !s.Now().Before(ser.expires) {
+		delete(s.series, key)
+		return [][]byte{}, nil
+	}
+	members := make([]string, 0, len(ser.points))
+	for member, score := range ser.points {
+		if score >= min && score <= max {
+			members = append(members, member)
+		}
+	}
+	// Same order as the redis sorted set - by score, then by data
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := ser.points[members[i]], ser.points[members[j]]
+		if si == sj {
+			return members[i] < members[j]
+		}
+		return si < sj
+	})
+
+	ret := make([][]byte, len(members))
+	for i, member := range members {
+		ret[i] = []byte(member)
+	}
+	return ret, nil
+}
+
+func (s *MemoryStore) SetField(ctx context.Context, key string, field string, data []byte, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	h := s.hash(key, true)
+	h.fields[field] = append([]byte(nil), data...)
+	h.expires = s.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) SetFieldIfMissing(ctx context.Context, key string, field string, data []byte, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	h := s.hash(key, true)
+	h.expires = s.Now().Add(ttl)
+	if _, ok := h.fields[field]; ok {
+		return false, nil
+	}
+	h.fields[field] = append([]byte(nil), data...)
+	return true, nil
+}
+
+func (s *MemoryStore) GetFields(ctx context.Context, key string) (map[string][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make(map[string][]byte)
+	if h := s.hash(key, false); h != nil {
+		for field, data := range h.fields {
+			ret[field] = append([]byte(nil), data...)
+		}
+	}
+	return ret, nil
+}
+
+func (s *MemoryStore) DeleteFields(ctx context.Context, key string, fields ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if h := s.hash(key, false); h != nil {
+		for _, field := range fields {
+			delete(h.fields, field)
+		}
+	}
+	return nil
+}
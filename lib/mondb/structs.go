@@ -1,7 +1,22 @@
 package mondb
 
+import "time"
+
+// MonitorMeta is who/what registered a monitor and why - all optional
+type MonitorMeta struct {
+	Owner        string    `json:"owner,omitempty"`  // Who registered it
+	RegisteredAt time.Time `json:"registered-at"`    // Zero for monitors from before this was tracked
+	Client       string    `json:"client,omitempty"` // What registered it - api client or the monitor it came from
+	Labels       []string  `json:"labels,omitempty"` // Free-form tags - copied into kafka headers for routing
+	Notes        string    `json:"notes,omitempty"`
+}
+
 type BaseMonitor struct {
 	MonitorName string `json:"monitor-name"`
+	Platform    string `json:"platform,omitempty"` // DonorDrive platform id - empty is the default platform
+	MonitorMeta
+	MonitorWindow
+	Milestones *Milestones `json:"milestones,omitempty"` // Nil uses the defaults
 }
 
 type TeamMonitor struct {
@@ -9,6 +24,11 @@ type TeamMonitor struct {
 	TeamID int `json:"team-id"`
 }
 
+type EventMonitor struct {
+	*BaseMonitor
+	EventID int `json:"event-id"`
+}
+
 type ParticipantMonitor struct {
 	*BaseMonitor
 	ParticipantID int `json:"participant-id"`
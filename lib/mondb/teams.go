@@ -8,17 +8,24 @@ import (
 	"fmt"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/gcache"
-	"github.com/go-redis/redis/v8"
-	"github.com/mailgun/groupcache/v2"
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"text/template"
+	"time"
 )
 
 func init() {
 	// Keep to things that are immutable for the team
-	viper.SetDefault("team.monitor.template", `{{ .TeamID }}-{{ .EventID }}`)
+	viper.SetDefault("team.monitor.template", `{{ .KeyPrefix }}{{ .TeamID }}-{{ .EventID }}`)
+}
+
+// teamKeyData is what the team key template runs against - the platform is the monitor's, as teams cached before
+// platforms existed don't have one
+type teamKeyData struct {
+	*df.CachedTeam
+	Platform  string
+	KeyPrefix string // Empty for the default platform, otherwise the platform and a -
 }
 
 func (t *TeamMonitor) GetKey() string {
@@ -26,7 +33,12 @@ func (t *TeamMonitor) GetKey() string {
 }
 
 func (t *TeamMonitor) MonitorKey() string {
-	return t.MakeKey(t.GetKey())
+	return t.MakeKey(t.platformKey(t.GetKey())...)
+}
+
+//CacheKey is the groupcache key for the team
+func (t *TeamMonitor) CacheKey() string {
+	return t.cacheKey(t.GetKey())
 }
 
 //TeamKafkaKeyEvents is used in kafka for identity - For events topic (compacted) - tl;dr All data, not fetch time though
@@ -51,7 +63,7 @@ func (t *TeamMonitor) TeamKafkaKeyTeams(team *df.CachedTeam) ([]byte, error) {
 	}
 
 	bf := new(bytes.Buffer)
-	if err := tplate.Execute(bf, team); err != nil {
+	if err := tplate.Execute(bf, teamKeyData{CachedTeam: team, Platform: t.GetPlatform(), KeyPrefix: t.kafkaKeyPrefix()}); err != nil {
 		log.WithError(err).Error("Problem executing team monitor template")
 		return nil, err
 	}
@@ -60,7 +72,7 @@ func (t *TeamMonitor) TeamKafkaKeyTeams(team *df.CachedTeam) ([]byte, error) {
 
 //TeamKafkaHeaders are used in kafka for info, routing, and debugging
 func (t *TeamMonitor) TeamKafkaHeaders(team *df.CachedTeam) []kafka.Header {
-	ret := make([]kafka.Header, 0)
+	ret := append([]kafka.Header{t.platformHeader()}, t.metaHeaders()...)
 	if team.TeamID != nil {
 		ret = append(ret, kafka.Header{
 			Key:   df.KHeaderKeyTeamID,
@@ -124,113 +136,77 @@ func (t *TeamMonitor) MakeEventsMessages(team *df.CachedTeam) ([]kafka.Message,
 
 //SetUpdateMonitoring turns on monitoring for team.active period
 func (t *TeamMonitor) SetUpdateMonitoring(ctx context.Context) error {
-	rClient, err := GetRedisClient()
-	if err != nil {
-		return err
-	}
+	return t.setUpdateMonitoring(ctx, viper.GetDuration("team.active"))
+}
 
+//ExtendUpdateMonitoring turns on monitoring for at least the given duration - won't shorten an existing longer period
+// New monitors inherit the parent's metadata, existing ones keep their own
+func (t *TeamMonitor) ExtendUpdateMonitoring(ctx context.Context, duration time.Duration, parent Monitor) error {
+	st := GetStore()
 	key := t.MonitorKey()
-
-	data, err := json.Marshal(t)
-	if err != nil {
-		return err
-	}
-
-	if err := rClient.Set(ctx, key, data, viper.GetDuration("team.active")).Err(); err != nil {
+	left, err := st.TTL(ctx, key)
+	switch {
+	case errors.Is(err, ErrNotStored):
+		t.InheritMeta(parent.GetBase(), parent.MonitorKey())
+		return t.setUpdateMonitoring(ctx, duration)
+	case err != nil:
 		return err
+	case left == NoExpiry || left >= duration: // Already long enough
+		return nil
 	}
+	return st.Expire(ctx, indexKey(t.MonitorName), key, duration)
+}
 
-	// Make lookups for what we have quick - will have to verify where they point exists
-	if err := rClient.SAdd(ctx, t.GetLookupKey(TeamMonitorIDSet), key).Err(); err != nil {
+func (t *TeamMonitor) setUpdateMonitoring(ctx context.Context, duration time.Duration) error {
+	data, err := json.Marshal(t)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	// Indexed by expiry so listing only has to look at live monitors
+	return GetStore().Save(ctx, indexKey(t.MonitorName), t.MonitorKey(), data, t.windowTTL(duration, time.Now()))
 }
 
-//AmMonitoring are we monitoring this id
+//AmMonitoring are we monitoring this id right now - loads the stored monitor's metadata if it exists
 func (t *TeamMonitor) AmMonitoring(ctx context.Context) (bool, error) {
-	rClient, err := GetRedisClient()
+	loaded, err := t.Load(ctx)
 	if err != nil {
 		return false, err
 	}
+	return loaded && t.InWindow(time.Now()), nil
+}
 
-	key := t.MakeKey(t.GetKey())
-	cnt, err := rClient.Exists(ctx, key).Result()
-	if err != nil {
-		return false, err
-	}
-	return cnt == 1, nil
+//Load fills in the monitor from redis - false if it's not there
+func (t *TeamMonitor) Load(ctx context.Context) (bool, error) {
+	return loadMonitor(ctx, t.MonitorKey(), t)
 }
 
-//GetAllTeams returns a list of all monitored teams
+//GetAllTeams returns a list of all monitored teams that are in their window
 func GetAllTeams(ctx context.Context) ([]*TeamMonitor, error) {
-	log := df.Log
-
-	rClient, err := GetRedisClient()
-	if err != nil {
-		log.WithError(err).Error("Problem getting redis client")
-		return nil, err
-	}
-
-	sKey := GetLookupKey(df.MonitorNameTeam, TeamMonitorIDSet)
-	log = log.WithField("teams.key", sKey)
-
-	keys, err := rClient.SMembers(ctx, sKey).Result()
-	if err != nil {
-		log.WithError(err).Error("Problem getting monitor id set")
-		return nil, err
-	}
-	log = log.WithField("set.len", len(keys))
-
-	ret := make([]*TeamMonitor, 0) // Can't assume len - might have to remove some
-	for _, key := range keys {
-		log := log.WithField("key", key)
-		data, err := rClient.Get(ctx, key).Bytes()
-		if errors.Is(err, redis.Nil) {
-			log.Trace("Doesn't exist")
-			continue
-		}
-		if err != nil {
-			// Something else happened
-			log.WithError(err).Error("Problem with member key lookup")
-			return nil, err
-		}
-
-		tm, err := NewTeamMonitorFromJSON(data)
-		if err != nil {
-			log.WithError(err).Error("Problem with turning json data into team")
-			return nil, err
-		}
-
-		// While it existed earlier, maybe in the future other checks will be here - so do it again
-		if amMon, err := tm.AmMonitoring(ctx); err != nil {
-			log.WithError(err).Info("Problem getting monitoring info")
-			return nil, err
-		} else if !amMon {
-			log.WithError(err).Info("Not monitoring anymore")
-			continue // Not monitoring
-		}
+	return getTeams(ctx, true)
+}
 
-		ret = append(ret, tm)
-	}
+//EachTeamBatch calls fn with the monitored teams that are in their window - at most monitor.list.batch at a time
+func EachTeamBatch(ctx context.Context, fn func([]*TeamMonitor) error) error {
+	return eachMonitorBatch(ctx, df.MonitorNameTeam, true, NewTeamMonitorFromJSON, fn)
+}
 
-	return ret, nil
+//getTeams lists the stored team monitors - activeOnly skips ones outside of their window
+func getTeams(ctx context.Context, activeOnly bool) ([]*TeamMonitor, error) {
+	return listMonitors(ctx, df.MonitorNameTeam, activeOnly, NewTeamMonitorFromJSON)
 }
 
 //GetTeam gets the cached team info
 func (t *TeamMonitor) GetTeam(ctx context.Context) (*df.CachedTeam, error) {
-	log := df.Log.WithField("team.id", t.TeamID)
+	log := df.Log.WithFields(logrus.Fields{
+		"team.id":             t.TeamID,
+		"donordrive.platform": t.GetPlatform(),
+	})
 	gca := gcache.GlobalCache()
-	teamGC, err := gca.GetGroupByName(gcache.GroupELTeam)
-	if err != nil {
-		log.WithError(err).Error("Problem getting gca group by name")
-		return nil, err
-	}
 
 	log.Trace("Kicking off cache get/fill")
-	var data []byte
-	if err := teamGC.Get(ctx, t.GetKey(), groupcache.AllocatingByteSliceSink(&data)); err != nil {
+	data, err := gca.Fetch(ctx, gcache.GroupELTeam, t.CacheKey())
+	if err != nil {
 		log.WithError(err).Error("Couldn't get entry from team's group cache")
 		return nil, err
 	}
@@ -250,17 +226,15 @@ func (t *TeamMonitor) GetTeam(ctx context.Context) (*df.CachedTeam, error) {
 
 //GetTeamParticipants gets the cached list of participants for the team
 func (t *TeamMonitor) GetTeamParticipants(ctx context.Context) (*df.CachedParticipants, error) {
-	log := df.Log.WithField("team.id", t.TeamID)
+	log := df.Log.WithFields(logrus.Fields{
+		"team.id":             t.TeamID,
+		"donordrive.platform": t.GetPlatform(),
+	})
 	gca := gcache.GlobalCache()
-	teamPGC, err := gca.GetGroupByName(gcache.GroupELParticipantForTeam)
-	if err != nil {
-		log.WithError(err).Error("Problem getting gca group by name")
-		return nil, err
-	}
 
 	log.Trace("Kicking off cache get/fill")
-	var data []byte
-	if err := teamPGC.Get(ctx, t.GetKey(), groupcache.AllocatingByteSliceSink(&data)); err != nil {
+	data, err := gca.Fetch(ctx, gcache.GroupELParticipantForTeam, t.CacheKey())
+	if err != nil {
 		log.WithError(err).Error("Couldn't get entry from participants's group cache")
 		return nil, err
 	}
@@ -0,0 +1,55 @@
+package mondb
+
+import (
+	"context"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/gcache"
+)
+
+func init() {
+	gcache.RegisterWarmer(df.MonitorNameTeam, warmTeams)
+	gcache.RegisterWarmer(df.MonitorNameParticipant, warmParticipants)
+	gcache.RegisterWarmer(df.MonitorNameEvent, warmEvents)
+}
+
+//warmTeams lists the cache keys for all monitored teams
+func warmTeams(ctx context.Context) ([]gcache.WarmKey, error) {
+	teams, err := GetAllTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]gcache.WarmKey, 0, len(teams)*2)
+	for _, tm := range teams {
+		ret = append(ret,
+			gcache.WarmKey{Group: gcache.GroupELTeam, Key: tm.CacheKey()},
+			gcache.WarmKey{Group: gcache.GroupELParticipantForTeam, Key: tm.CacheKey()},
+		)
+	}
+	return ret, nil
+}
+
+//warmParticipants lists the cache keys for all monitored participants
+func warmParticipants(ctx context.Context) ([]gcache.WarmKey, error) {
+	participants, err := GetAllParticipants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]gcache.WarmKey, 0, len(participants))
+	for _, pm := range participants {
+		ret = append(ret, gcache.WarmKey{Group: gcache.GroupELParticipants, Key: pm.CacheKey()})
+	}
+	return ret, nil
+}
+
+//warmEvents lists the cache keys for all monitored events
+func warmEvents(ctx context.Context) ([]gcache.WarmKey, error) {
+	events, err := GetAllEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]gcache.WarmKey, 0, len(events))
+	for _, em := range events {
+		ret = append(ret, gcache.WarmKey{Group: gcache.GroupELEvent, Key: em.CacheKey()})
+	}
+	return ret, nil
+}
@@ -0,0 +1,113 @@
+package mondb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	_ "time/tzdata" // Don't depend on the host having zoneinfo
+)
+
+const (
+	WindowPending = "pending" // Start is in the future
+	WindowActive  = "active"
+	WindowEnded   = "ended" // Only seen briefly - the redis key expires at the end
+	// Shortest ttl a window gives - an ended window still has to expire, a zero or negative ttl would never expire
+	minWindowTTL = time.Second
+)
+
+// MonitorWindow is when a monitor should be polled - both ends are optional
+type MonitorWindow struct {
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Timezone string     `json:"timezone,omitempty"` // IANA name the window was given in
+}
+
+var (
+	ErrInvalidWindow   = errors.New("invalid monitoring window")
+	ErrInvalidTimezone = errors.New("invalid timezone")
+	// Tried in order for times without an offset - they're in the window's timezone
+	localLayouts = []string{
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+	}
+)
+
+//NewMonitorWindow parses a window - times are RFC3339 or local times in tz (UTC if empty), and either can be empty
+func NewMonitorWindow(start string, end string, tz string) (MonitorWindow, error) {
+	loc, err := time.LoadLocation(tz) // "" is UTC
+	if err != nil {
+		return MonitorWindow{}, fmt.Errorf("%w: %q", ErrInvalidTimezone, tz)
+	}
+
+	ret := MonitorWindow{Timezone: loc.String()}
+	if ret.Start, err = parseWindowTime(start, loc); err != nil {
+		return MonitorWindow{}, err
+	}
+	if ret.End, err = parseWindowTime(end, loc); err != nil {
+		return MonitorWindow{}, err
+	}
+
+	if ret.End != nil {
+		if ret.Start != nil && !ret.End.After(*ret.Start) {
+			return MonitorWindow{}, fmt.Errorf("%w: end isn't after start", ErrInvalidWindow)
+		}
+		if !ret.End.After(time.Now()) {
+			return MonitorWindow{}, fmt.Errorf("%w: end has already passed", ErrInvalidWindow)
+		}
+	}
+	if ret.Start == nil && ret.End == nil {
+		ret.Timezone = "" // Nothing to show it for
+	}
+	return ret, nil
+}
+
+//parseWindowTime parses a single window time into UTC - nil if it's empty
+func parseWindowTime(v string, loc *time.Location) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		t = t.UTC()
+		return &t, nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			t = t.UTC()
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: can't parse time %q", ErrInvalidWindow, v)
+}
+
+//WindowState is where now is relative to the window
+func (w *MonitorWindow) WindowState(now time.Time) string {
+	switch {
+	case w.Start != nil && now.Before(*w.Start):
+		return WindowPending
+	case w.End != nil && !now.Before(*w.End):
+		return WindowEnded
+	}
+	return WindowActive
+}
+
+//InWindow checks if the monitor should be polled at the given time
+func (w *MonitorWindow) InWindow(now time.Time) bool {
+	return w.WindowState(now) == WindowActive
+}
+
+//windowTTL is how long to keep the monitor in redis - until the end if there is one, otherwise active for def after it
+// starts. Never less than minWindowTTL, so a monitor saved after its window ended still goes away.
+func (w *MonitorWindow) windowTTL(def time.Duration, now time.Time) time.Duration {
+	if w.End != nil {
+		if ttl := w.End.Sub(now); ttl > minWindowTTL {
+			return ttl
+		}
+		return minWindowTTL
+	}
+	if w.Start != nil && w.Start.After(now) {
+		return w.Start.Sub(now) + def
+	}
+	return def
+}
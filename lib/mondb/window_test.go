@@ -0,0 +1,120 @@
+package mondb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewMonitorWindow(t *testing.T) {
+	future := time.Now().Add(time.Hour * 24 * 365).UTC().Truncate(time.Second)
+	later := future.Add(time.Hour * 2)
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		tz    string
+		want  MonitorWindow
+		err   error
+	}{
+		{name: "empty", want: MonitorWindow{}},
+		{
+			name:  "rfc3339",
+			start: future.Format(time.RFC3339),
+			end:   later.Format(time.RFC3339),
+			want:  MonitorWindow{Start: &future, End: &later, Timezone: "UTC"},
+		},
+		{
+			name:  "local time in timezone",
+			start: "2100-01-06T08:00",
+			tz:    "America/New_York",
+			want:  MonitorWindow{Start: timePtr(time.Date(2100, 1, 6, 13, 0, 0, 0, time.UTC)), Timezone: "America/New_York"},
+		},
+		{
+			name: "local time with seconds and a space",
+			end:  "2100-11-06 08:00:30",
+			want: MonitorWindow{End: timePtr(time.Date(2100, 11, 6, 8, 0, 30, 0, time.UTC)), Timezone: "UTC"},
+		},
+		{name: "bad timezone", start: "2100-11-06T08:00", tz: "Mars/Olympus_Mons", err: ErrInvalidTimezone},
+		{name: "bad time", start: "next tuesday", err: ErrInvalidWindow},
+		{name: "end before start", start: later.Format(time.RFC3339), end: future.Format(time.RFC3339), err: ErrInvalidWindow},
+		{name: "end already passed", end: "2000-01-01T00:00:00Z", err: ErrInvalidWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMonitorWindow(tt.start, tt.end, tt.tz)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if !sameTime(got.Start, tt.want.Start) || !sameTime(got.End, tt.want.End) || got.Timezone != tt.want.Timezone {
+				t.Errorf("got %v - %v (%q), want %v - %v (%q)", got.Start, got.End, got.Timezone, tt.want.Start, tt.want.End, tt.want.Timezone)
+			}
+		})
+	}
+}
+
+func TestWindowState(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name   string
+		window MonitorWindow
+		want   string
+	}{
+		{name: "no window", window: MonitorWindow{}, want: WindowActive},
+		{name: "not started", window: MonitorWindow{Start: &after}, want: WindowPending},
+		{name: "started", window: MonitorWindow{Start: &before}, want: WindowActive},
+		{name: "in window", window: MonitorWindow{Start: &before, End: &after}, want: WindowActive},
+		{name: "ended", window: MonitorWindow{End: &before}, want: WindowEnded},
+		{name: "ends now", window: MonitorWindow{End: &now}, want: WindowEnded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.WindowState(now); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+			if got := tt.window.InWindow(now); got != (tt.want == WindowActive) {
+				t.Errorf("InWindow got %v for %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowTTL(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	def := time.Minute * 30
+	tests := []struct {
+		name   string
+		window MonitorWindow
+		want   time.Duration
+	}{
+		{name: "no window", window: MonitorWindow{}, want: def},
+		{name: "started", window: MonitorWindow{Start: &before}, want: def},
+		{name: "not started", window: MonitorWindow{Start: &after}, want: time.Hour + def},
+		{name: "until end", window: MonitorWindow{Start: &before, End: &after}, want: time.Hour},
+		{name: "ended", window: MonitorWindow{End: &before}, want: minWindowTTL},
+		{name: "ends now", window: MonitorWindow{End: &now}, want: minWindowTTL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.windowTTL(def, now); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,112 @@
+(function () {
+    "use strict";
+
+    var body = document.body;
+    var cfg = {
+        widget: body.dataset.widget,
+        endpoint: body.dataset.endpoint,
+        refresh: parseInt(body.dataset.refresh, 10) || 30000,
+        limit: parseInt(body.dataset.limit, 10) || 5
+    };
+
+    function field(name) {
+        return document.querySelector('[data-field="' + name + '"]');
+    }
+
+    function money(v) {
+        return "$" + (v || 0).toLocaleString(undefined, {minimumFractionDigits: 0, maximumFractionDigits: 2});
+    }
+
+    // Build list items via textContent so names/messages can't inject markup
+    function item(tag, parts) {
+        var el = document.createElement(tag);
+        parts.forEach(function (p) {
+            var span = document.createElement("span");
+            span.className = p[0];
+            span.textContent = p[1];
+            el.appendChild(span);
+        });
+        return el;
+    }
+
+    function fillList(items) {
+        var list = field("list");
+        while (list.firstChild) {
+            list.removeChild(list.firstChild);
+        }
+        items.forEach(function (i) {
+            list.appendChild(i);
+        });
+    }
+
+    var renderers = {
+        "thermometer": function (data) {
+            var obj = data.team ? data.team.team : data.participant.participant;
+            var raised = obj.sumDonations || 0;
+            var goal = obj.fundraisingGoal || 0;
+            var pct = goal > 0 ? Math.round(raised / goal * 100) : 0;
+            field("raised").textContent = money(raised);
+            field("goal").textContent = money(goal);
+            field("percent").textContent = pct;
+            field("fill").style.width = Math.min(pct, 100) + "%";
+        },
+        "donations": function (data) {
+            var donations = (data.donations.donations || []).slice();
+            donations.sort(function (a, b) {
+                return (b.createdDateUTC || "").localeCompare(a.createdDateUTC || "");
+            });
+            fillList(donations.slice(0, cfg.limit).map(function (d) {
+                return item("li", [
+                    ["name", (d.displayName || "Anonymous") + " "],
+                    ["amount", d.amount ? money(d.amount) : ""],
+                    ["message", d.message || ""]
+                ]);
+            }));
+        },
+        "top-donors": function (data) {
+            var totals = {};
+            (data.donations.donations || []).forEach(function (d) {
+                var name = d.displayName || "Anonymous";
+                totals[name] = (totals[name] || 0) + (d.amount || 0);
+            });
+            var donors = Object.keys(totals).map(function (k) {
+                return {name: k, total: totals[k]};
+            });
+            donors.sort(function (a, b) {
+                return b.total - a.total;
+            });
+            fillList(donors.slice(0, cfg.limit).map(function (d) {
+                return item("li", [["name", d.name + " "], ["amount", money(d.total)]]);
+            }));
+        },
+        "roster": function (data) {
+            var members = (data.participants.participants || []).slice();
+            members.sort(function (a, b) {
+                return (b.sumDonations || 0) - (a.sumDonations || 0);
+            });
+            fillList(members.slice(0, cfg.limit).map(function (p) {
+                return item("li", [["name", p.displayName + " "], ["amount", money(p.sumDonations)]]);
+            }));
+        }
+    };
+
+    function refresh() {
+        fetch(cfg.endpoint, {headers: {"Accept": "application/json"}})
+            .then(function (res) {
+                if (!res.ok) {
+                    throw new Error("status " + res.status);
+                }
+                return res.json();
+            })
+            .then(renderers[cfg.widget])
+            .catch(function (err) {
+                // Keep showing the last good data
+                console.log("overlay refresh failed", err);
+            })
+            .then(function () {
+                setTimeout(refresh, cfg.refresh);
+            });
+    }
+
+    refresh();
+})();
@@ -0,0 +1,253 @@
+package overlays
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/spf13/viper"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WidgetThermometer = "thermometer"
+	WidgetDonations   = "donations"
+	WidgetTopDonors   = "top-donors"
+	WidgetRoster      = "roster"
+)
+
+// Widget is an embeddable overlay page
+type Widget struct {
+	Name  string            // Name used in the URL and as the template name
+	Title string            // Default title if none is given via the query string
+	Feeds map[string]string // Request type => cached endpoint format (takes the id)
+}
+
+// Theme holds the query string options used to style an overlay
+type Theme struct {
+	Background string
+	Foreground string
+	Accent     string
+	Font       string
+	FontSize   int
+	Title      string
+	Limit      int
+	Refresh    time.Duration
+}
+
+// PageData is what gets handed to the widget templates
+type PageData struct {
+	Widget   *Widget
+	RType    string
+	ID       string
+	Endpoint string
+	Theme    *Theme
+}
+
+//go:embed assets/templates/*.html
+var templateFS embed.FS
+
+//go:embed assets/static
+var staticFS embed.FS
+
+var (
+	widgets            map[string]*Widget
+	wLock              *sync.Mutex
+	tmpl               *template.Template
+	tLock              *sync.Mutex
+	hexColorRe         = regexp.MustCompile(`^#([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+	colorNameRe        = regexp.MustCompile(`^[a-zA-Z]{1,32}$`)
+	hexDigitsRe        = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	fontRe             = regexp.MustCompile(`^[a-zA-Z0-9 ,\-]{1,64}$`)
+	ErrNoSuchWidget    = errors.New("no such overlay widget")
+	ErrUnsupportedType = errors.New("overlay widget doesn't support that type")
+)
+
+func init() {
+	doCheckInits()
+	viper.SetDefault("overlay.refresh.default", time.Second*30)
+	viper.SetDefault("overlay.refresh.min", time.Second*10)
+	viper.SetDefault("overlay.limit.default", 5)
+	viper.SetDefault("overlay.limit.max", 50)
+
+	RegisterWidget(&Widget{
+		Name:  WidgetThermometer,
+		Title: "Goal",
+		Feeds: map[string]string{
+			df.RTypeTeam:        "/v1/team/%s/",
+			df.RTypeParticipant: "/v1/participant/%s/",
+		},
+	})
+	RegisterWidget(&Widget{
+		Name:  WidgetDonations,
+		Title: "Recent Donations",
+		Feeds: map[string]string{
+			df.RTypeParticipant: "/v1/participant/%s/donations/",
+		},
+	})
+	RegisterWidget(&Widget{
+		Name:  WidgetTopDonors,
+		Title: "Top Donors",
+		Feeds: map[string]string{
+			df.RTypeParticipant: "/v1/participant/%s/donations/",
+		},
+	})
+	RegisterWidget(&Widget{
+		Name:  WidgetRoster,
+		Title: "Team Roster",
+		Feeds: map[string]string{
+			df.RTypeTeam: "/v1/team/%s/participants/",
+		},
+	})
+}
+
+//doCheckInits runs various local inits that need to run before others can do stuff - Safe to rerun many times
+func doCheckInits() {
+	if wLock == nil {
+		wLock = &sync.Mutex{}
+	}
+	if tLock == nil {
+		tLock = &sync.Mutex{}
+	}
+	if widgets == nil {
+		wLock.Lock()
+		widgets = make(map[string]*Widget)
+		wLock.Unlock()
+	}
+}
+
+//RegisterWidget adds a widget - A template with the same name must exist
+func RegisterWidget(w *Widget) {
+	doCheckInits()
+
+	wLock.Lock()
+	defer wLock.Unlock()
+	widgets[w.Name] = w
+}
+
+//GetWidget returns the named widget
+func GetWidget(name string) (*Widget, error) {
+	wLock.Lock()
+	defer wLock.Unlock()
+
+	w, ok := widgets[name]
+	if !ok {
+		return nil, ErrNoSuchWidget
+	}
+	return w, nil
+}
+
+//Endpoint builds the cached endpoint the widget should poll for the given type and id - an empty platform uses the default platform's routes
+func (w *Widget) Endpoint(platform string, rType string, id string) (string, error) {
+	f, ok := w.Feeds[rType]
+	if !ok {
+		return "", ErrUnsupportedType
+	}
+	ep := fmt.Sprintf(f, url.PathEscape(id))
+	if platform != "" {
+		ep = "/v1/platform/" + url.PathEscape(platform) + strings.TrimPrefix(ep, "/v1")
+	}
+	return ep, nil
+}
+
+//ParseTheme builds a theme from query string options - bad values are ignored
+func ParseTheme(q url.Values, w *Widget) *Theme {
+	t := Theme{
+		Background: "transparent",
+		Foreground: "#ffffff",
+		Accent:     "#f26522",
+		Font:       "Helvetica, Arial, sans-serif",
+		FontSize:   24,
+		Title:      w.Title,
+		Limit:      viper.GetInt("overlay.limit.default"),
+		Refresh:    viper.GetDuration("overlay.refresh.default"),
+	}
+
+	t.Background = parseColor(q.Get("bg"), t.Background)
+	t.Foreground = parseColor(q.Get("fg"), t.Foreground)
+	t.Accent = parseColor(q.Get("accent"), t.Accent)
+	if v := q.Get("font"); fontRe.MatchString(v) {
+		t.Font = v
+	}
+	if v, err := strconv.Atoi(q.Get("size")); err == nil && v >= 8 && v <= 200 {
+		t.FontSize = v
+	}
+	if v, ok := q["title"]; ok {
+		t.Title = v[0] // Escaped by html/template - allow empty to hide it
+	}
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		t.Limit = v
+		if max := viper.GetInt("overlay.limit.max"); t.Limit > max {
+			t.Limit = max
+		}
+	}
+	if v, err := strconv.Atoi(q.Get("refresh")); err == nil {
+		t.Refresh = time.Second * time.Duration(v)
+		if min := viper.GetDuration("overlay.refresh.min"); t.Refresh < min {
+			t.Refresh = min
+		}
+	}
+
+	return &t
+}
+
+//parseColor checks a css color from the query string - hex with or without the #, or a color name - def if it's bad
+// Hex is tried first, and names that are all hex digits (e.g. fffff) aren't names, as no css color is spelled that way
+func parseColor(v string, def string) string {
+	switch {
+	case hexColorRe.MatchString("#" + v):
+		return "#" + v
+	case hexColorRe.MatchString(v):
+		return v
+	case colorNameRe.MatchString(v) && !hexDigitsRe.MatchString(v):
+		return v
+	}
+	return def
+}
+
+//RefreshMS is the refresh period in milliseconds for use in JS
+func (t *Theme) RefreshMS() int64 {
+	return t.Refresh.Milliseconds()
+}
+
+//getTemplates parses the embedded templates once
+func getTemplates() (*template.Template, error) {
+	tLock.Lock()
+	defer tLock.Unlock()
+
+	if tmpl == nil {
+		t, err := template.ParseFS(templateFS, "assets/templates/*.html")
+		if err != nil {
+			return nil, err
+		}
+		tmpl = t
+	}
+	return tmpl, nil
+}
+
+//Render writes out the widget page
+func Render(wr io.Writer, data *PageData) error {
+	t, err := getTemplates()
+	if err != nil {
+		return err
+	}
+	return t.ExecuteTemplate(wr, data.Widget.Name, data)
+}
+
+//StaticFS returns the embedded static assets (js/css) used by the overlays
+func StaticFS() fs.FS {
+	sub, err := fs.Sub(staticFS, "assets/static")
+	if err != nil {
+		// Only possible if the embed path is wrong
+		panic(fmt.Sprintf("Problem getting static overlay assets: %v", err))
+	}
+	return sub
+}
@@ -0,0 +1,46 @@
+package overlays
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		v    string
+		want string
+	}{
+		{v: "", want: "def"},
+		{v: "ffffff", want: "#ffffff"},
+		{v: "fff", want: "#fff"},
+		{v: "abcdef", want: "#abcdef"},
+		{v: "fffa", want: "#fffa"},
+		{v: "12345678", want: "#12345678"},
+		{v: "#00ff00", want: "#00ff00"},
+		{v: "#ABC", want: "#ABC"},
+		{v: "red", want: "red"},
+		{v: "rebeccapurple", want: "rebeccapurple"},
+		{v: "fffff", want: "def"},
+		{v: "#1234567", want: "def"},
+		{v: "#ff", want: "def"},
+		{v: "red;}", want: "def"},
+		{v: "url(x)", want: "def"},
+		{v: "#ggg", want: "def"},
+		{v: "bad", want: "#bad"},
+		{v: "beef0", want: "def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			if got := parseColor(tt.v, "def"); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseThemeColors(t *testing.T) {
+	theme := ParseTheme(url.Values{"bg": {"abcdef"}, "fg": {"fff"}, "accent": {"bad!"}}, &Widget{Title: "Goal"})
+	if theme.Background != "#abcdef" || theme.Foreground != "#fff" || theme.Accent != "#f26522" {
+		t.Errorf("got bg %q, fg %q, accent %q", theme.Background, theme.Foreground, theme.Accent)
+	}
+}
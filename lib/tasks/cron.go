@@ -4,6 +4,7 @@ import (
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/hibiken/asynq"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"time"
 )
 
@@ -11,15 +12,15 @@ func RegisterSched(scheduler *asynq.Scheduler) {
 	log := df.Log
 	//https://github.com/hibiken/asynq/wiki/Periodic-Tasks#entries
 
-	// Quick, very frequently run update jobs
-	// FIXME: Move durations to viper
-	registerUpdateJob(log, scheduler, NewExtraLifeTeamsUpdateTask(), time.Second*60)
-	registerUpdateJob(log, scheduler, NewExtraLifeParticipantsUpdateTask(), time.Second*120)
+	// Quick, very frequently run fan-out jobs - they only queue monitors that are due, based on their cadence tier
+	tick := viper.GetDuration("cadence.tick")
+	registerUpdateJob(log, scheduler, NewExtraLifeTeamsUpdateTask(), tick)
+	registerUpdateJob(log, scheduler, NewExtraLifeParticipantsUpdateTask(), tick)
+	registerUpdateJob(log, scheduler, NewExtraLifeEventsUpdateTask(), tick)
 }
 
 //registerUpdateJob helper to register quick update tasks
 func registerUpdateJob(log *logrus.Entry, scheduler *asynq.Scheduler, task *asynq.Task, cadence time.Duration) {
-	// FIXME: Move period to viper
 	entryID, err := scheduler.Register("@every "+cadence.String(), task) // Never retry it!
 	if err != nil {
 		log.WithError(err).Fatal("Couldn't register cron job")
@@ -0,0 +1,207 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fragforce/fragevents/lib/df"
+	"github.com/fragforce/fragevents/lib/mondb"
+	"github.com/fragforce/fragevents/lib/tracing"
+	"github.com/hibiken/asynq"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	TaskExtraLifeEventUpdate  = "extralife:event_update"
+	TaskExtraLifeEventsUpdate = "extralife:events_update"
+)
+
+type ELEventID struct {
+	EventID  int
+	Platform string            `json:"platform,omitempty"` // DonorDrive platform id - empty is the default platform
+	Force    bool              // Skip the monitoring check - used for admin refreshes
+	Trace    map[string]string `json:"trace,omitempty"` // Trace context of whoever queued us
+}
+
+//NewExtraLifeEventsUpdateTask runs an update check of all monitored events
+func NewExtraLifeEventsUpdateTask() *asynq.Task {
+	return asynq.NewTask(TaskExtraLifeEventsUpdate, nil, asynq.MaxRetry(0))
+}
+
+func HandleExtraLifeEventsUpdateTask(ctx context.Context, t *asynq.Task) error {
+	log := df.Log.WithField("task.type", t.Type()).WithContext(ctx)
+
+	count, dueCount := 0, 0
+	err := mondb.EachEventBatch(ctx, func(eMonitors []*mondb.EventMonitor) error {
+		count += len(eMonitors)
+		keys := make([]string, 0, len(eMonitors))
+		for _, eMonitor := range eMonitors {
+			keys = append(keys, eMonitor.MonitorKey())
+		}
+		due, err := mondb.DueKeys(ctx, df.MonitorNameEvent, keys)
+		if err != nil {
+			log.WithError(err).Error("Problem checking which events are due")
+			return err
+		}
+		dueCount += len(due)
+		return enqueueEventUpdates(ctx, log, eMonitors, due)
+	})
+	log = log.WithFields(logrus.Fields{
+		"events.count": count,
+		"events.due":   dueCount,
+	})
+	if err != nil {
+		log.WithError(err).Error("Problem going over all events")
+		return err
+	}
+	log.Trace("Done with triggering el event updates")
+
+	return nil
+}
+
+//enqueueEventUpdates queues the event updates for the events that are due
+func enqueueEventUpdates(ctx context.Context, log *logrus.Entry, eMonitors []*mondb.EventMonitor, due map[string]bool) error {
+	aClient := df.GetAsyncQClient()
+
+	for _, eMonitor := range eMonitors {
+		log := log.WithFields(logrus.Fields{
+			"event.id":            eMonitor.EventID,
+			"monitor.name":        eMonitor.MonitorName,
+			"donordrive.platform": eMonitor.GetPlatform(),
+		})
+		if eMonitor.EventID == 0 {
+			log.Info("Ran into zero event id - skipping")
+			continue
+		}
+		if !due[eMonitor.MonitorKey()] {
+			continue
+		}
+
+		task, err := NewExtraLifeEventUpdateTask(ctx, eMonitor.Platform, eMonitor.EventID)
+		if err != nil {
+			log.WithError(err).Error("Problem creating event update task")
+			return err
+		}
+
+		tInfo, err := aClient.Enqueue(task)
+		if err != nil {
+			log.WithError(err).Error("Problem enqueuing task")
+			return err
+		}
+		log.WithField("task.id", tInfo.ID).Trace("Task queued")
+	}
+	return nil
+}
+
+//NewExtraLifeEventUpdateTask runs an update check for the given monitored event
+func NewExtraLifeEventUpdateTask(ctx context.Context, platform string, eventID int) (*asynq.Task, error) {
+	return newExtraLifeEventUpdateTask(ctx, platform, eventID, false)
+}
+
+//NewExtraLifeEventRefreshTask runs an update for the given event even if it's not monitored
+func NewExtraLifeEventRefreshTask(ctx context.Context, platform string, eventID int) (*asynq.Task, error) {
+	return newExtraLifeEventUpdateTask(ctx, platform, eventID, true)
+}
+
+func newExtraLifeEventUpdateTask(ctx context.Context, platform string, eventID int, force bool) (*asynq.Task, error) {
+	if eventID == 0 {
+		return nil, ErrInvalidID
+	}
+	payload, err := json.Marshal(ELEventID{EventID: eventID, Platform: platform, Force: force, Trace: tracing.InjectMap(ctx)})
+	if err != nil {
+		return nil, err
+	}
+	return asynq.NewTask(TaskExtraLifeEventUpdate, payload, asynq.Timeout(time.Minute*20), asynq.MaxRetry(0)), nil
+}
+
+//HandleExtraLifeEventUpdateTask publishes the event info then makes sure all of its teams are monitored - unless forced
+func HandleExtraLifeEventUpdateTask(ctx context.Context, t *asynq.Task) error {
+	log := df.Log.WithField("task.type", t.Type()).WithContext(ctx)
+	log.Trace("Doing event update")
+
+	p := ELEventID{}
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		log.WithError(err).Error("Problem unmarshalling payload")
+		return err
+	}
+	log = log.WithFields(logrus.Fields{
+		"event.id":            p.EventID,
+		"donordrive.platform": p.Platform,
+	})
+
+	if p.EventID == 0 {
+		log.WithError(ErrInvalidID).Info("Invalid event id")
+		return ErrInvalidID
+	}
+
+	em := mondb.NewEventMonitor(p.Platform, p.EventID)
+
+	if p.Force {
+		log.Debug("Forced update - skipping monitoring check")
+		// Still want any owner/labels for the kafka headers
+		if _, err := em.Load(ctx); err != nil {
+			log.WithError(err).Info("Problem loading monitor metadata - continuing without it")
+		}
+	} else {
+		log.Trace("Checking monitoring")
+		amMon, err := em.AmMonitoring(ctx)
+		if err != nil {
+			log.WithError(err).Error("Problem checking if monitored")
+			return err
+		}
+		log = log.WithField("event.monitoring", amMon)
+		if !amMon {
+			log.Debug("Not monitored anymore - skipping update")
+			return nil
+		}
+	}
+
+	event, err := em.GetEvent(ctx)
+	if err != nil {
+		log.WithError(err).Error("Problem getting event from gca")
+		return err
+	}
+	log = log.WithFields(logrus.Fields{
+		"teams.count":  event.TeamCount,
+		"last-refresh": event.GetFetchedAt(),
+	})
+
+	// Not worth failing the update over
+	act := mondb.Activity{}
+	for _, team := range event.Teams {
+		act.Live = act.Live || (team.StreamIsLive != nil && *team.StreamIsLive)
+		if team.SumDonations != nil {
+			act.Total += *team.SumDonations
+		}
+	}
+	if tier, every, err := mondb.UpdateCadence(ctx, em, act); err != nil {
+		log.WithError(err).Warn("Problem scheduling next event update")
+	} else {
+		log.WithFields(logrus.Fields{
+			"cadence.tier":  tier,
+			"cadence.every": every,
+		}).Trace("Scheduled next update")
+	}
+
+	log.Trace("Recording to event-info topic")
+	if err := em.WriteEventToKafka(ctx, event); err != nil {
+		log.WithError(err).Error("Problem writing to kafka")
+		return err
+	}
+
+	// Only for monitored events, or refreshing one would start monitoring all of its teams
+	if p.Force {
+		log.Trace("Done with forced event update")
+		return nil
+	}
+
+	// The team cron picks these up from here
+	cnt, err := em.MonitorTeams(ctx, event)
+	if err != nil {
+		log.WithError(err).Error("Problem marking event teams as monitored")
+		return err
+	}
+
+	log.WithField("teams.monitored", cnt).Trace("Done with event update")
+	return nil
+}
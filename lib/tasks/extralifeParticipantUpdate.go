@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/mondb"
+	"github.com/fragforce/fragevents/lib/tracing"
 	"github.com/hibiken/asynq"
 	"github.com/sirupsen/logrus"
 	"time"
@@ -17,27 +18,55 @@ func NewExtraLifeParticipantsUpdateTask() *asynq.Task {
 
 func HandleExtraLifeParticipantsUpdateTask(ctx context.Context, t *asynq.Task) error {
 	log := df.Log.WithField("task.type", t.Type()).WithContext(ctx)
-	aClient := df.GetAsyncQClient()
 
-	pMonitors, err := mondb.GetAllParticipants(ctx)
+	count, dueCount := 0, 0
+	err := mondb.EachParticipantBatch(ctx, func(pMonitors []*mondb.ParticipantMonitor) error {
+		count += len(pMonitors)
+		keys := make([]string, 0, len(pMonitors))
+		for _, pMonitor := range pMonitors {
+			keys = append(keys, pMonitor.MonitorKey())
+		}
+		due, err := mondb.DueKeys(ctx, df.MonitorNameParticipant, keys)
+		if err != nil {
+			log.WithError(err).Error("Problem checking which participants are due")
+			return err
+		}
+		dueCount += len(due)
+		return enqueueParticipantUpdates(ctx, log, pMonitors, due)
+	})
+	log = log.WithFields(logrus.Fields{
+		"participants.count": count,
+		"participants.due":   dueCount,
+	})
 	if err != nil {
-		log.WithError(err).Error("Problem getting all participants")
+		log.WithError(err).Error("Problem going over all participants")
 		return err
 	}
-	log = log.WithField("participants.count", len(pMonitors))
+	log.Trace("Done with triggering el participant updates")
+
+	return nil
+}
+
+//enqueueParticipantUpdates queues the participant updates for the participants that are due
+func enqueueParticipantUpdates(ctx context.Context, log *logrus.Entry, pMonitors []*mondb.ParticipantMonitor, due map[string]bool) error {
+	aClient := df.GetAsyncQClient()
 
 	for idx, pMonitor := range pMonitors {
 		log := log.WithFields(logrus.Fields{
-			"participant.id": pMonitor.ParticipantID,
-			"monitor.name":   pMonitor.MonitorName,
-			"monitor.idx":    idx,
+			"participant.id":      pMonitor.ParticipantID,
+			"monitor.name":        pMonitor.MonitorName,
+			"monitor.idx":         idx,
+			"donordrive.platform": pMonitor.GetPlatform(),
 		})
 		if pMonitor.ParticipantID == 0 {
 			log.Info("Skipping nil ParticipantID")
 			continue
 		}
+		if !due[pMonitor.MonitorKey()] {
+			continue
+		}
 
-		task, err := NewExtraLifeParticipantUpdateTask(pMonitor.ParticipantID)
+		task, err := NewExtraLifeParticipantUpdateTask(ctx, pMonitor.Platform, pMonitor.ParticipantID)
 		if err != nil {
 			log.WithError(err).Error("Problem creating participant update task")
 			return err
@@ -50,17 +79,24 @@ func HandleExtraLifeParticipantsUpdateTask(ctx context.Context, t *asynq.Task) e
 		}
 		log.WithField("task.id", tInfo.ID).Trace("Task queued")
 	}
-	log.Trace("Done with triggering el participant updates")
-
 	return nil
 }
 
 //NewExtraLifeParticipantUpdateTask runs an update check for the given monitored participant
-func NewExtraLifeParticipantUpdateTask(participantID int) (*asynq.Task, error) {
+func NewExtraLifeParticipantUpdateTask(ctx context.Context, platform string, participantID int) (*asynq.Task, error) {
+	return newExtraLifeParticipantUpdateTask(ctx, platform, participantID, false)
+}
+
+//NewExtraLifeParticipantRefreshTask runs an update for the given participant even if it's not monitored
+func NewExtraLifeParticipantRefreshTask(ctx context.Context, platform string, participantID int) (*asynq.Task, error) {
+	return newExtraLifeParticipantUpdateTask(ctx, platform, participantID, true)
+}
+
+func newExtraLifeParticipantUpdateTask(ctx context.Context, platform string, participantID int, force bool) (*asynq.Task, error) {
 	if participantID == 0 {
 		return nil, ErrInvalidID
 	}
-	payload, err := json.Marshal(ELParticipantID{ParticipantID: participantID})
+	payload, err := json.Marshal(ELParticipantID{ParticipantID: participantID, Platform: platform, Force: force, Trace: tracing.InjectMap(ctx)})
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +116,8 @@ func HandleExtraLifeParticipantUpdateTask(ctx context.Context, t *asynq.Task) er
 		return err
 	}
 	log = log.WithFields(logrus.Fields{
-		"participants.id": p.ParticipantID,
+		"participants.id":     p.ParticipantID,
+		"donordrive.platform": p.Platform,
 	})
 
 	if p.ParticipantID == 0 {
@@ -88,23 +125,66 @@ func HandleExtraLifeParticipantUpdateTask(ctx context.Context, t *asynq.Task) er
 		return ErrInvalidID
 	}
 
-	tm := mondb.NewParticipantMonitor(p.ParticipantID)
+	tm := mondb.NewParticipantMonitor(p.Platform, p.ParticipantID)
+
+	if p.Force {
+		log.Debug("Forced update - skipping monitoring check")
+		// Still want any owner/labels for the kafka headers
+		if _, err := tm.Load(ctx); err != nil {
+			log.WithError(err).Info("Problem loading monitor metadata - continuing without it")
+		}
+	} else {
+		log.Trace("Checking monitoring")
+		amMon, err := tm.AmMonitoring(ctx)
+		if err != nil {
+			log.WithError(err).Error("Problem checking if monitored")
+			return err
+		}
+		log = log.WithField("participants.monitoring", amMon)
+		if !amMon {
+			log.Debug("Not monitored anymore - skipping update")
+			return nil
+		}
+	}
 
-	log.Trace("Checking monitoring")
-	amMon, err := tm.AmMonitoring(ctx)
+	participant, err := tm.WriteParticipantToKafka(ctx)
 	if err != nil {
-		log.WithError(err).Error("Problem checking if monitored")
+		log.WithError(err).Error("Problem writing to kafka")
 		return err
 	}
-	log = log.WithField("participants.monitoring", amMon)
-	if !amMon {
-		log.Debug("Not monitored anymore - skipping update")
-		return nil
+
+	// Not worth failing the update over
+	act := mondb.Activity{Live: participant.StreamIsLive, Total: participant.SumDonations}
+	if tier, every, err := mondb.UpdateCadence(ctx, tm, act); err != nil {
+		log.WithError(err).Warn("Problem scheduling next participant update")
+	} else {
+		log.WithFields(logrus.Fields{
+			"cadence.tier":  tier,
+			"cadence.every": every,
+		}).Trace("Scheduled next update")
 	}
 
-	if err := tm.WriteParticipantToKafka(ctx); err != nil {
-		log.WithError(err).Error("Problem writing to kafka")
-		return err
+	// Same for history - DonorDrive doesn't give a count for participants, so it's from their donations
+	goal := participant.FundraisingGoal
+	point := &mondb.HistoryPoint{
+		At:     participant.FetchedAt,
+		Raised: participant.SumDonations,
+		Goal:   &goal,
+	}
+	if donations, err := tm.GetParticipantDonations(ctx); err != nil {
+		log.WithError(err).Info("Problem getting participant donations - recording history without a count")
+	} else {
+		point.Count = &donations.Count
+	}
+	if err := mondb.RecordHistory(ctx, df.MonitorNameParticipant, tm.GetPlatform(), p.ParticipantID, point); err != nil {
+		log.WithError(err).Warn("Problem recording participant history")
+	}
+
+	// Missed ones are tried again on the next update
+	if cnt, err := mondb.ReachMilestones(ctx, tm, df.RTypeParticipant, p.ParticipantID, participant.SumDonations, &goal); err != nil {
+		log.WithError(err).Warn("Problem with participant milestones")
+	} else if cnt > 0 {
+		log.WithField("milestones.reached", cnt).Debug("Participant reached milestones")
 	}
 
 	return nil
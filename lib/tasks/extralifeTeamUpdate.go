@@ -7,6 +7,7 @@ import (
 	"github.com/fragforce/fragevents/lib/df"
 	"github.com/fragforce/fragevents/lib/kdb"
 	"github.com/fragforce/fragevents/lib/mondb"
+	"github.com/fragforce/fragevents/lib/tracing"
 	"github.com/hibiken/asynq"
 	"github.com/sirupsen/logrus"
 	"time"
@@ -21,11 +22,17 @@ const (
 )
 
 type ELTeamID struct {
-	TeamID int
+	TeamID   int
+	Platform string            `json:"platform,omitempty"` // DonorDrive platform id - empty is the default platform
+	Force    bool              // Skip the monitoring check - used for admin refreshes
+	Trace    map[string]string `json:"trace,omitempty"` // Trace context of whoever queued us
 }
 
 type ELParticipantID struct {
 	ParticipantID int
+	Platform      string            `json:"platform,omitempty"` // DonorDrive platform id - empty is the default platform
+	Force         bool              // Skip the monitoring check - used for admin refreshes
+	Trace         map[string]string `json:"trace,omitempty"` // Trace context of whoever queued us
 }
 
 var (
@@ -33,12 +40,21 @@ var (
 )
 
 //NewExtraLifeTeamUpdateTask runs an update check for the given monitored team
-func NewExtraLifeTeamUpdateTask(teamID int) (*asynq.Task, error) {
+func NewExtraLifeTeamUpdateTask(ctx context.Context, platform string, teamID int) (*asynq.Task, error) {
+	return newExtraLifeTeamUpdateTask(ctx, platform, teamID, false)
+}
+
+//NewExtraLifeTeamRefreshTask runs an update for the given team even if it's not monitored
+func NewExtraLifeTeamRefreshTask(ctx context.Context, platform string, teamID int) (*asynq.Task, error) {
+	return newExtraLifeTeamUpdateTask(ctx, platform, teamID, true)
+}
+
+func newExtraLifeTeamUpdateTask(ctx context.Context, platform string, teamID int, force bool) (*asynq.Task, error) {
 	if teamID == 0 {
 		return nil, ErrInvalidID
 	}
 
-	payload, err := json.Marshal(ELTeamID{TeamID: teamID})
+	payload, err := json.Marshal(ELTeamID{TeamID: teamID, Platform: platform, Force: force, Trace: tracing.InjectMap(ctx)})
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +71,8 @@ func HandleExtraLifeTeamUpdateTask(ctx context.Context, t *asynq.Task) error {
 		return err
 	}
 	log = log.WithFields(logrus.Fields{
-		"team.id": p.TeamID,
+		"team.id":             p.TeamID,
+		"donordrive.platform": p.Platform,
 	})
 
 	if p.TeamID == 0 {
@@ -64,18 +81,26 @@ func HandleExtraLifeTeamUpdateTask(ctx context.Context, t *asynq.Task) error {
 	}
 
 	// TODO: Maybe move this into TeamMonitor...?
-	tm := mondb.NewTeamMonitor(p.TeamID)
+	tm := mondb.NewTeamMonitor(p.Platform, p.TeamID)
 
-	log.Trace("Checking monitoring")
-	amMon, err := tm.AmMonitoring(ctx)
-	if err != nil {
-		log.WithError(err).Error("Problem checking if monitored")
-		return err
-	}
-	log = log.WithField("team.monitoring", amMon)
-	if !amMon {
-		log.Debug("Not monitored anymore - skipping update")
-		return nil
+	if p.Force {
+		log.Debug("Forced update - skipping monitoring check")
+		// Still want any owner/labels for the kafka headers
+		if _, err := tm.Load(ctx); err != nil {
+			log.WithError(err).Info("Problem loading monitor metadata - continuing without it")
+		}
+	} else {
+		log.Trace("Checking monitoring")
+		amMon, err := tm.AmMonitoring(ctx)
+		if err != nil {
+			log.WithError(err).Error("Problem checking if monitored")
+			return err
+		}
+		log = log.WithField("team.monitoring", amMon)
+		if !amMon {
+			log.Debug("Not monitored anymore - skipping update")
+			return nil
+		}
 	}
 
 	log.Trace("Getting team")
@@ -95,6 +120,37 @@ func HandleExtraLifeTeamUpdateTask(ctx context.Context, t *asynq.Task) error {
 	})
 	log.Trace("Got team")
 
+	// Not worth failing the update over
+	act := mondb.Activity{Live: team.StreamIsLive != nil && *team.StreamIsLive}
+	if team.SumDonations != nil {
+		act.Total = *team.SumDonations
+	}
+	if tier, every, err := mondb.UpdateCadence(ctx, tm, act); err != nil {
+		log.WithError(err).Warn("Problem scheduling next team update")
+	} else {
+		log.WithFields(logrus.Fields{
+			"cadence.tier":  tier,
+			"cadence.every": every,
+		}).Trace("Scheduled next update")
+	}
+
+	// Same for history
+	if err := mondb.RecordHistory(ctx, df.MonitorNameTeam, tm.GetPlatform(), p.TeamID, &mondb.HistoryPoint{
+		At:     team.FetchedAt,
+		Raised: act.Total,
+		Goal:   team.FundraisingGoal,
+		Count:  team.NumDonations,
+	}); err != nil {
+		log.WithError(err).Warn("Problem recording team history")
+	}
+
+	// Missed ones are tried again on the next update
+	if cnt, err := mondb.ReachMilestones(ctx, tm, df.RTypeTeam, p.TeamID, act.Total, team.FundraisingGoal); err != nil {
+		log.WithError(err).Warn("Problem with team milestones")
+	} else if cnt > 0 {
+		log.WithField("milestones.reached", cnt).Debug("Team reached milestones")
+	}
+
 	log.Trace("Recording to teams topic")
 	// TODO: Maybe move this into TeamMonitor...?
 	kWriteTeams, err := kdb.W.Get(ctx, kdb.MakeTopicName(df.KTopicTeams))
@@ -108,6 +164,7 @@ func HandleExtraLifeTeamUpdateTask(ctx context.Context, t *asynq.Task) error {
 		log.WithError(err).Error("Problem making kafka message(s)")
 		return err
 	}
+	tracing.InjectKafka(ctx, msgs)
 	c1, can1 := context.WithTimeout(ctx, time.Second*120)
 	defer can1()
 	if err := kWriteTeams.WriteMessages(
@@ -131,6 +188,7 @@ func HandleExtraLifeTeamUpdateTask(ctx context.Context, t *asynq.Task) error {
 		log.WithError(err).Error("Problem making kafka message(s)")
 		return err
 	}
+	tracing.InjectKafka(ctx, msgs)
 	c2, can2 := context.WithTimeout(ctx, time.Second*120)
 	defer can2()
 	if err := kWriteEvents.WriteMessages(
@@ -152,20 +210,48 @@ func NewExtraLifeTeamsUpdateTask() *asynq.Task {
 
 func HandleExtraLifeTeamsUpdateTask(ctx context.Context, t *asynq.Task) error {
 	log := df.Log.WithField("task.type", t.Type()).WithContext(ctx)
-	aClient := df.GetAsyncQClient()
 
-	teamMonitors, err := mondb.GetAllTeams(ctx)
+	count, dueCount := 0, 0
+	err := mondb.EachTeamBatch(ctx, func(teamMonitors []*mondb.TeamMonitor) error {
+		count += len(teamMonitors)
+		keys := make([]string, 0, len(teamMonitors))
+		for _, teamMonitor := range teamMonitors {
+			keys = append(keys, teamMonitor.MonitorKey())
+		}
+		due, err := mondb.DueKeys(ctx, df.MonitorNameTeam, keys)
+		if err != nil {
+			log.WithError(err).Error("Problem checking which teams are due")
+			return err
+		}
+		dueCount += len(due)
+		return enqueueTeamUpdates(ctx, log, teamMonitors, due)
+	})
+	log = log.WithFields(logrus.Fields{
+		"teams.count": count,
+		"teams.due":   dueCount,
+	})
 	if err != nil {
-		log.WithError(err).Error("Problem getting all teams")
+		log.WithError(err).Error("Problem going over all teams")
 		return err
 	}
-	log = log.WithField("teams.count", len(teamMonitors))
+	log.Trace("Done with triggering el team updates")
+
+	return nil
+}
+
+//enqueueTeamUpdates queues the team and team participant updates for the teams that are due
+func enqueueTeamUpdates(ctx context.Context, log *logrus.Entry, teamMonitors []*mondb.TeamMonitor, due map[string]bool) error {
+	aClient := df.GetAsyncQClient()
 
 	for _, teamMonitor := range teamMonitors {
 		log := log.WithFields(logrus.Fields{
-			"team.id":      teamMonitor.TeamID,
-			"monitor.name": teamMonitor.MonitorName,
+			"team.id":             teamMonitor.TeamID,
+			"monitor.name":        teamMonitor.MonitorName,
+			"donordrive.platform": teamMonitor.GetPlatform(),
 		})
+		if !due[teamMonitor.MonitorKey()] {
+			continue
+		}
 
 		if teamMonitor.TeamID == 0 {
 			log.Info("Ran into zero team id - skipping")
@@ -173,7 +259,7 @@ func HandleExtraLifeTeamsUpdateTask(ctx context.Context, t *asynq.Task) error {
 		}
 		tMon := teamMonitor.TeamID
 
-		task, err := NewExtraLifeTeamUpdateTask(tMon)
+		task, err := NewExtraLifeTeamUpdateTask(ctx, teamMonitor.Platform, tMon)
 		if err != nil {
 			log.WithError(err).Error("Problem creating team update task")
 			return err
@@ -186,7 +272,7 @@ func HandleExtraLifeTeamsUpdateTask(ctx context.Context, t *asynq.Task) error {
 		}
 		log.WithField("task.id", tInfo.ID).Trace("Task 1 queued")
 
-		task2, err := NewExtraLifeTeamUpdateParticipantTask(tMon)
+		task2, err := NewExtraLifeTeamUpdateParticipantTask(ctx, teamMonitor.Platform, tMon)
 		if err != nil {
 			log.WithError(err).Error("Problem creating team participant update task")
 			return err
@@ -199,17 +285,24 @@ func HandleExtraLifeTeamsUpdateTask(ctx context.Context, t *asynq.Task) error {
 		}
 		log.WithField("task.id", tInfo2.ID).Trace("Task 2 queued")
 	}
-	log.Trace("Done with triggering el team updates")
-
 	return nil
 }
 
 //NewExtraLifeTeamUpdateParticipantTask runs an update check for the given monitored team - Runs over participants
-func NewExtraLifeTeamUpdateParticipantTask(teamID int) (*asynq.Task, error) {
+func NewExtraLifeTeamUpdateParticipantTask(ctx context.Context, platform string, teamID int) (*asynq.Task, error) {
+	return newExtraLifeTeamUpdateParticipantTask(ctx, platform, teamID, false)
+}
+
+//NewExtraLifeTeamRefreshParticipantTask runs an update over the team's participants even if it's not monitored
+func NewExtraLifeTeamRefreshParticipantTask(ctx context.Context, platform string, teamID int) (*asynq.Task, error) {
+	return newExtraLifeTeamUpdateParticipantTask(ctx, platform, teamID, true)
+}
+
+func newExtraLifeTeamUpdateParticipantTask(ctx context.Context, platform string, teamID int, force bool) (*asynq.Task, error) {
 	if teamID == 0 {
 		return nil, ErrInvalidID
 	}
-	payload, err := json.Marshal(ELTeamID{TeamID: teamID})
+	payload, err := json.Marshal(ELTeamID{TeamID: teamID, Platform: platform, Force: force, Trace: tracing.InjectMap(ctx)})
 	if err != nil {
 		return nil, err
 	}
@@ -227,7 +320,8 @@ func HandleExtraLifeTeamUpdateParticipantTask(ctx context.Context, t *asynq.Task
 		return err
 	}
 	log = log.WithFields(logrus.Fields{
-		"team.id": p.TeamID,
+		"team.id":             p.TeamID,
+		"donordrive.platform": p.Platform,
 	})
 
 	if p.TeamID == 0 {
@@ -236,18 +330,22 @@ func HandleExtraLifeTeamUpdateParticipantTask(ctx context.Context, t *asynq.Task
 	}
 
 	// TODO: Maybe move this into TeamMonitor...?
-	tm := mondb.NewTeamMonitor(p.TeamID)
+	tm := mondb.NewTeamMonitor(p.Platform, p.TeamID)
 
-	log.Trace("Checking monitoring")
-	amMon, err := tm.AmMonitoring(ctx)
-	if err != nil {
-		log.WithError(err).Error("Problem checking if monitored")
-		return err
-	}
-	log = log.WithField("participants.monitoring", amMon)
-	if !amMon {
-		log.Debug("Not monitored anymore - skipping update")
-		return nil
+	if p.Force {
+		log.Debug("Forced update - skipping monitoring check")
+	} else {
+		log.Trace("Checking monitoring")
+		amMon, err := tm.AmMonitoring(ctx)
+		if err != nil {
+			log.WithError(err).Error("Problem checking if monitored")
+			return err
+		}
+		log = log.WithField("participants.monitoring", amMon)
+		if !amMon {
+			log.Debug("Not monitored anymore - skipping update")
+			return nil
+		}
 	}
 
 	participants, err := tm.GetTeamParticipants(ctx)
@@ -256,8 +354,33 @@ func HandleExtraLifeTeamUpdateParticipantTask(ctx context.Context, t *asynq.Task
 		return err
 	}
 
-	for _, p := range participants.Participants {
-		task, err := NewExtraLifeParticipantUpdateTask(p.ParticipantId)
+	// Only for monitored teams, and not from a failed fetch or everyone would 'leave'
+	if !p.Force && !participants.Stale {
+		if err := updateTeamRoster(ctx, log, tm, participants); err != nil {
+			return err
+		}
+	}
+
+	// Refreshes do everyone - otherwise only the ones their cadence says are due
+	var due map[string]bool
+	if !p.Force {
+		keys := make([]string, 0, len(participants.Participants))
+		for _, participant := range participants.Participants {
+			keys = append(keys, mondb.NewParticipantMonitor(p.Platform, participant.ParticipantId).MonitorKey())
+		}
+		due, err = mondb.DueKeys(ctx, df.MonitorNameParticipant, keys)
+		if err != nil {
+			log.WithError(err).Error("Problem checking which participants are due")
+			return err
+		}
+		log = log.WithField("participants.due", len(due))
+	}
+
+	for _, participant := range participants.Participants {
+		if due != nil && !due[mondb.NewParticipantMonitor(p.Platform, participant.ParticipantId).MonitorKey()] {
+			continue
+		}
+		task, err := newExtraLifeParticipantUpdateTask(ctx, p.Platform, participant.ParticipantId, p.Force)
 		if err != nil {
 			log.WithError(err).Error("Problem creating participant update task")
 			return err
@@ -275,3 +398,38 @@ func HandleExtraLifeTeamUpdateParticipantTask(ctx context.Context, t *asynq.Task
 	log.Trace("Done with participants update")
 	return nil
 }
+
+//updateTeamRoster publishes who joined and left the team since last time, starts/stops their monitoring, then saves the roster
+// The roster is saved last so a failure gets the same changes again next time
+func updateTeamRoster(ctx context.Context, log *logrus.Entry, tm *mondb.TeamMonitor, participants *df.CachedParticipants) error {
+	changes, err := tm.RosterChanges(ctx, participants)
+	if err != nil {
+		log.WithError(err).Error("Problem working out roster changes")
+		return err
+	}
+	log = log.WithField("roster.changes", len(changes))
+
+	if err := tm.WriteRosterChangesToKafka(ctx, changes); err != nil {
+		log.WithError(err).Error("Problem writing roster changes")
+		return err
+	}
+
+	started, stopped, err := tm.ApplyRosterChanges(ctx, changes)
+	log = log.WithFields(logrus.Fields{
+		"roster.started": started,
+		"roster.stopped": stopped,
+	})
+	if err != nil {
+		log.WithError(err).Error("Problem updating participant monitoring for roster changes")
+		return err
+	}
+
+	if err := tm.SaveRoster(ctx, participants); err != nil {
+		log.WithError(err).Error("Problem saving roster")
+		return err
+	}
+	if len(changes) > 0 {
+		log.Debug("Team roster changed")
+	}
+	return nil
+}
@@ -1,14 +1,21 @@
 package tasks
 
-import "github.com/hibiken/asynq"
+import (
+	"github.com/fragforce/fragevents/lib/metrics"
+	"github.com/fragforce/fragevents/lib/tracing"
+	"github.com/hibiken/asynq"
+)
 
 //GetMux maps names to handlers
 func GetMux() *asynq.ServeMux {
 	mux := asynq.NewServeMux()
+	mux.Use(tracing.AsynqMiddleware, metrics.AsynqMiddleware)
 	mux.HandleFunc(TaskExtraLifeTeamUpdate, HandleExtraLifeTeamUpdateTask)
 	mux.HandleFunc(TaskExtraLifeParticipantUpdate, HandleExtraLifeParticipantUpdateTask)
 	mux.HandleFunc(TaskExtraLifeTeamParticipantUpdate, HandleExtraLifeTeamUpdateParticipantTask)
 	mux.HandleFunc(TaskExtraLifeTeamsUpdate, HandleExtraLifeTeamsUpdateTask)
 	mux.HandleFunc(TaskExtraLifeParticipantsUpdate, HandleExtraLifeParticipantsUpdateTask)
+	mux.HandleFunc(TaskExtraLifeEventUpdate, HandleExtraLifeEventUpdateTask)
+	mux.HandleFunc(TaskExtraLifeEventsUpdate, HandleExtraLifeEventsUpdateTask)
 	return mux
 }
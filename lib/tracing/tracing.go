@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hibiken/asynq"
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	TracerName     = "github.com/fragforce/fragevents"
+)
+
+// ShutdownFunc flushes and stops the tracer provider
+type ShutdownFunc func(ctx context.Context) error
+
+// taskTrace is the part of a task payload we care about - payloads are json objects with an optional trace field
+type taskTrace struct {
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+var (
+	ErrUnknownExporter = errors.New("unknown tracing exporter")
+)
+
+func init() {
+	viper.SetDefault("tracing.exporter", ExporterNone)          // none, otlp or stdout
+	viper.SetDefault("tracing.otlp.endpoint", "localhost:4318") // host:port of the OTLP/HTTP collector
+	viper.SetDefault("tracing.otlp.insecure", true)             // Use http rather than https to talk to the collector
+	viper.SetDefault("tracing.sample.ratio", 1.0)               // Fraction of new traces to sample - remote parents decide for their children
+	viper.SetDefault("tracing.service", "fragevents")
+	viper.SetDefault("tracing.shutdown.timeout", time.Second*5) // Max time to flush spans on exit
+
+	// Always propagate, even if we're not exporting, so traces pass through us intact
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+//Init sets up the global tracer provider via 'tracing.exporter' - role (e.g. web) is added as a resource attribute
+func Init(ctx context.Context, role string) (ShutdownFunc, error) {
+	var exporter sdktrace.SpanExporter
+	switch name := viper.GetString("tracing.exporter"); name {
+	case ExporterNone, "":
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(viper.GetString("tracing.otlp.endpoint"))}
+		if viper.GetBool("tracing.otlp.insecure") {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownExporter, name)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(viper.GetString("tracing.service")),
+		attribute.String("fragevents.role", role),
+		attribute.String("heroku.dyno", viper.GetString("runtime.dyno_id")),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(viper.GetFloat64("tracing.sample.ratio")))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+//Tracer is our shared tracer
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+//Start starts a span using our tracer
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+//End records err, if any, on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//InjectMap gets the trace context as a map - for task payloads
+func InjectMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+//ExtractMap adds the trace context from InjectMap to ctx
+func ExtractMap(ctx context.Context, m map[string]string) context.Context {
+	if len(m) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(m))
+}
+
+//InjectKafka adds the trace context to each message's headers
+func InjectKafka(ctx context.Context, msgs []kafka.Message) {
+	tc := InjectMap(ctx)
+	for i := range msgs {
+		for k, v := range tc {
+			msgs[i].Headers = append(msgs[i].Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+	}
+}
+
+//AsynqMiddleware continues the trace from the task payload's trace field and wraps the task in a span
+func AsynqMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		tt := taskTrace{}
+		if len(t.Payload()) > 0 {
+			// Not all payloads are objects - just don't link those
+			_ = json.Unmarshal(t.Payload(), &tt)
+		}
+		ctx = ExtractMap(ctx, tt.Trace)
+
+		ctx, span := Tracer().Start(ctx, "task "+t.Type(),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("asynq.task.type", t.Type())),
+		)
+		err := next.ProcessTask(ctx, t)
+		End(span, err)
+		return err
+	})
+}